@@ -0,0 +1,397 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/DLag/cachery"
+	"github.com/pkg/errors"
+)
+
+// Handler serves HTTP API for inspection and invalidation of caches in Manager.
+//
+// Routes relative to the mount point:
+//
+//	GET  /caches                            list of caches with configuration and statistics
+//	GET  /caches/{cache}                    single cache
+//	GET  /caches/{cache}/keys               page of keys if driver is cachery.Scanner, ?cursor=&count=
+//	GET  /caches/{cache}/keys/{key}         raw value and TTL of the key
+//	POST /caches/{cache}/invalidate         invalidate all keys of the cache
+//	POST /caches/{cache}/keys/{key}/invalidate  invalidate the key
+//	POST /caches/{cache}/keys/{key}/refresh     load the key from fetcher
+//	GET  /tags/{tag}                        list of caches with the tag
+//	POST /tags/{tag}/invalidate             invalidate caches with the tag
+//
+// Path segments are URL-unescaped, keys are passed to caches as strings
+type Handler struct {
+	// Auth optional hook which authorizes requests, unauthorized requests get 403 Forbidden
+	Auth    func(r *http.Request) bool
+	manager *cachery.Manager
+}
+
+// CacheInfo describes cache in responses
+type CacheInfo struct {
+	Name       string           `json:"name"`
+	Tags       []string         `json:"tags,omitempty"`
+	Expire     string           `json:"expire,omitempty"`
+	Lifetime   string           `json:"lifetime,omitempty"`
+	Driver     string           `json:"driver,omitempty"`
+	Serializer string           `json:"serializer,omitempty"`
+	Stats      map[string]int64 `json:"stats,omitempty"`
+}
+
+// KeyInfo describes key in responses
+type KeyInfo struct {
+	Cache string `json:"cache"`
+	Key   string `json:"key"`
+	TTL   string `json:"ttl"`
+	Size  int    `json:"size"`
+	// Value is set if serializer is able to decode the value without knowing its type (e.g. JSON)
+	// or if the cache stores objects (Config.ObjectMode)
+	Value interface{} `json:"value,omitempty"`
+	// Raw is serialized value, it's encoded with base64 in JSON
+	Raw []byte `json:"raw"`
+}
+
+// KeysPage is a page of keys of the cache, Cursor is zero on the last page
+type KeysPage struct {
+	Keys   []string `json:"keys"`
+	Cursor uint64   `json:"cursor"`
+}
+
+type configurer interface {
+	Config() cachery.Config
+}
+
+type statser interface {
+	Stats() map[string]int64
+}
+
+type refresher interface {
+	Refresh(key interface{}) error
+}
+
+type response struct {
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// New creates an instance of Handler for Manager
+func New(manager *cachery.Manager) *Handler {
+	h := new(Handler)
+	h.manager = manager
+	return h
+}
+
+// ServeHTTP handles requests to the API
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Auth != nil && !h.Auth(r) {
+		writeError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	path, err := splitPath(r.URL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch {
+	case len(path) == 1 && path[0] == "caches", len(path) == 2 && path[0] == "caches" && path[1] == "":
+		h.get(w, r, h.listCaches)
+	case len(path) == 2 && path[0] == "caches":
+		h.get(w, r, func(w http.ResponseWriter) {
+			h.cache(w, path[1])
+		})
+	case len(path) == 3 && path[0] == "caches" && path[2] == "invalidate":
+		h.post(w, r, func(w http.ResponseWriter) {
+			h.invalidateCache(w, path[1])
+		})
+	case len(path) == 3 && path[0] == "caches" && path[2] == "keys":
+		h.get(w, r, func(w http.ResponseWriter) {
+			h.keys(w, r, path[1])
+		})
+	case len(path) == 4 && path[0] == "caches" && path[2] == "keys":
+		h.get(w, r, func(w http.ResponseWriter) {
+			h.key(w, path[1], path[3])
+		})
+	case len(path) == 5 && path[0] == "caches" && path[2] == "keys" && path[4] == "invalidate":
+		h.post(w, r, func(w http.ResponseWriter) {
+			h.invalidateKey(w, path[1], path[3])
+		})
+	case len(path) == 5 && path[0] == "caches" && path[2] == "keys" && path[4] == "refresh":
+		h.post(w, r, func(w http.ResponseWriter) {
+			h.refreshKey(w, path[1], path[3])
+		})
+	case len(path) == 2 && path[0] == "tags":
+		h.get(w, r, func(w http.ResponseWriter) {
+			h.tag(w, path[1])
+		})
+	case len(path) == 3 && path[0] == "tags" && path[2] == "invalidate":
+		h.post(w, r, func(w http.ResponseWriter) {
+			h.manager.InvalidateTags(path[1])
+			writeJSON(w, http.StatusOK, response{Status: "OK"})
+		})
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, f func(w http.ResponseWriter)) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	f(w)
+}
+
+func (h *Handler) post(w http.ResponseWriter, r *http.Request, f func(w http.ResponseWriter)) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	f(w)
+}
+
+func (h *Handler) listCaches(w http.ResponseWriter) {
+	list := make([]CacheInfo, 0)
+	h.manager.Range(func(c cachery.Cache) bool {
+		list = append(list, info(c))
+		return true
+	})
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (h *Handler) cache(w http.ResponseWriter, name string) {
+	if c := h.lookup(w, name); c != nil {
+		writeJSON(w, http.StatusOK, info(c))
+	}
+}
+
+func (h *Handler) tag(w http.ResponseWriter, tag string) {
+	list := make([]CacheInfo, 0)
+	for _, c := range h.manager.CachesByTag(tag) {
+		list = append(list, info(c))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (h *Handler) invalidateCache(w http.ResponseWriter, name string) {
+	if c := h.lookup(w, name); c != nil {
+		c.InvalidateAll()
+		writeJSON(w, http.StatusOK, response{Status: "OK"})
+	}
+}
+
+// keys lists keys of the cache by Scanner, query parameters "cursor" and "count" are optional
+func (h *Handler) keys(w http.ResponseWriter, r *http.Request, name string) {
+	c := h.lookup(w, name)
+	if c == nil {
+		return
+	}
+	cc, ok := c.(configurer)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "cache doesn't expose its driver")
+		return
+	}
+	config := cc.Config()
+	scanner, ok := config.Driver.(cachery.Scanner)
+	// Transformed keys cannot be mapped back to original ones
+	if !ok || config.KeyTransformer != nil {
+		writeError(w, http.StatusNotImplemented, "driver doesn't support scanning")
+		return
+	}
+	var (
+		cursor uint64
+		count  int
+		err    error
+	)
+	q := r.URL.Query()
+	if v := q.Get("cursor"); v != "" {
+		if cursor, err = strconv.ParseUint(v, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "bad cursor")
+			return
+		}
+	}
+	if v := q.Get("count"); v != "" {
+		if count, err = strconv.Atoi(v); err != nil {
+			writeError(w, http.StatusBadRequest, "bad count")
+			return
+		}
+	}
+	keys, next, err := scanner.Scan(name, cursor, count)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	writeJSON(w, http.StatusOK, KeysPage{Keys: keys, Cursor: next})
+}
+
+func (h *Handler) key(w http.ResponseWriter, name, key string) {
+	c := h.lookup(w, name)
+	if c == nil {
+		return
+	}
+	cc, ok := c.(configurer)
+	if !ok || cc.Config().Driver == nil {
+		writeError(w, http.StatusNotImplemented, "cache doesn't expose its driver")
+		return
+	}
+	config := cc.Config()
+	driver := config.Driver
+	if config.KeyTransformer != nil {
+		driver = cachery.NewKeyDriver(driver, config.KeyTransformer, config.CheckKeyCollisions)
+	}
+	if od, ok := driver.(cachery.ObjectDriver); ok && config.ObjectMode != cachery.ObjectModeOff {
+		obj, ttl, err := od.GetObject(name, key)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, KeyInfo{Cache: name, Key: key, TTL: ttl.String(), Value: obj})
+		return
+	}
+	val, ttl, err := driver.Get(name, key)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	ki := KeyInfo{
+		Cache: name,
+		Key:   key,
+		TTL:   ttl.String(),
+		Size:  len(val),
+		Raw:   val,
+	}
+	if cs, ok := config.Serializer.(cachery.ContextSerializer); ok {
+		var v interface{}
+		if cs.DeserializeContext(val, &v, cachery.EntryContext(name, key)) == nil {
+			ki.Value = v
+		}
+	} else if config.Serializer != nil {
+		var v interface{}
+		if config.Serializer.Deserialize(val, &v) == nil {
+			ki.Value = v
+		}
+	}
+	writeJSON(w, http.StatusOK, ki)
+}
+
+func (h *Handler) invalidateKey(w http.ResponseWriter, name, key string) {
+	c := h.lookup(w, name)
+	if c == nil {
+		return
+	}
+	if err := c.Invalidate(key); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, response{Status: "OK"})
+}
+
+func (h *Handler) refreshKey(w http.ResponseWriter, name, key string) {
+	c := h.lookup(w, name)
+	if c == nil {
+		return
+	}
+	rc, ok := c.(refresher)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "cache doesn't support refresh")
+		return
+	}
+	if err := rc.Refresh(key); err != nil {
+		status := http.StatusInternalServerError
+		// Cache without Config.Fetcher cannot refresh keys by itself
+		if errors.Cause(err) == cachery.ErrNilFetcher {
+			status = http.StatusNotImplemented
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, response{Status: "OK"})
+}
+
+func (h *Handler) lookup(w http.ResponseWriter, name string) cachery.Cache {
+	c := h.manager.Get(name)
+	if c == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("cache %q not found", name))
+	}
+	return c
+}
+
+func info(c cachery.Cache) CacheInfo {
+	ci := CacheInfo{Name: c.Name()}
+	if tc, ok := c.(cachery.Tagger); ok {
+		ci.Tags = tc.Tags()
+	}
+	if cc, ok := c.(configurer); ok {
+		config := cc.Config()
+		ci.Expire = config.Expire.String()
+		ci.Lifetime = config.Lifetime.String()
+		if config.Driver != nil {
+			ci.Driver = fmt.Sprintf("%T", config.Driver)
+		}
+		if config.Serializer != nil {
+			ci.Serializer = fmt.Sprintf("%T", config.Serializer)
+		}
+	}
+	if sc, ok := c.(statser); ok {
+		ci.Stats = sc.Stats()
+	}
+	return ci
+}
+
+func splitPath(u *url.URL) ([]string, error) {
+	// Trailing slash is kept to allow empty keys
+	path := strings.TrimPrefix(u.EscapedPath(), "/")
+	if path == "" {
+		return nil, nil
+	}
+	segments := strings.Split(path, "/")
+	for i := range segments {
+		s, err := url.PathUnescape(segments[i])
+		if err != nil {
+			return nil, err
+		}
+		segments[i] = s
+	}
+	return segments, nil
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, response{Error: msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DLag/cachery"
+	"github.com/DLag/cachery/drivers/inmemory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	a := assert.New(t)
+	fetches := 0
+	fetcher := func(key interface{}) (interface{}, error) {
+		fetches++
+		return map[string]interface{}{"key": key}, nil
+	}
+	d := inmemory.Default()
+	m := new(cachery.Manager).Add(
+		cachery.NewDefault("CACHE1", cachery.Config{
+			Expire:     time.Minute,
+			Lifetime:   time.Hour,
+			Tags:       []string{"tag1"},
+			Serializer: new(cachery.JSONSerializer),
+			Driver:     d,
+			Fetcher:    fetcher,
+		}),
+		cachery.NewDefault("CACHE2", cachery.Config{
+			Expire:     time.Minute,
+			Lifetime:   time.Hour,
+			Serializer: new(cachery.GobSerializer),
+			Driver:     d,
+		}),
+	)
+	h := New(m)
+	h.Auth = func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "secret"
+	}
+	do := func(method, path string, dst interface{}) int {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		a.Equal("application/json", w.Header().Get("Content-Type"))
+		if dst != nil {
+			a.NoError(json.Unmarshal(w.Body.Bytes(), dst))
+		}
+		return w.Code
+	}
+	var val map[string]interface{}
+	a.NoError(m.Get("CACHE1").Get("a b", &val, nil))
+	a.Equal(1, fetches)
+
+	t.Run("Auth", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/caches", nil))
+		a.Equal(http.StatusForbidden, w.Code)
+	})
+	t.Run("Caches", func(t *testing.T) {
+		var list []CacheInfo
+		a.Equal(http.StatusOK, do(http.MethodGet, "/caches", &list))
+		a.Len(list, 2)
+		a.Equal("CACHE1", list[0].Name)
+		a.Equal([]string{"tag1"}, list[0].Tags)
+		a.Equal("1h0m0s", list[0].Lifetime)
+		a.Equal("*inmemory.Driver", list[0].Driver)
+		a.Equal("*cachery.JSONSerializer", list[0].Serializer)
+		a.Equal(int64(1), list[0].Stats["fetches"])
+
+		var ci CacheInfo
+		a.Equal(http.StatusOK, do(http.MethodGet, "/caches/CACHE2", &ci))
+		a.Equal("CACHE2", ci.Name)
+		a.Equal(http.StatusNotFound, do(http.MethodGet, "/caches/CACHE3", nil))
+		a.Equal(http.StatusOK, do(http.MethodGet, "/caches/", &list))
+		a.Len(list, 2)
+		a.Equal(http.StatusMethodNotAllowed, do(http.MethodPost, "/caches", nil))
+		a.Equal(http.StatusNotFound, do(http.MethodGet, "/unknown", nil))
+	})
+	t.Run("Tags", func(t *testing.T) {
+		var list []CacheInfo
+		a.Equal(http.StatusOK, do(http.MethodGet, "/tags/tag1", &list))
+		a.Len(list, 1)
+		a.Equal("CACHE1", list[0].Name)
+	})
+	t.Run("Key", func(t *testing.T) {
+		var ki KeyInfo
+		a.Equal(http.StatusOK, do(http.MethodGet, "/caches/CACHE1/keys/a%20b", &ki))
+		a.Equal("a b", ki.Key)
+		a.Equal(map[string]interface{}{"key": "a b"}, ki.Value)
+		a.Equal(`{"key":"a b"}`, string(ki.Raw))
+		a.Equal(len(ki.Raw), ki.Size)
+		a.Equal(http.StatusNotFound, do(http.MethodGet, "/caches/CACHE1/keys/c", nil))
+		a.Equal(http.StatusNotFound, do(http.MethodGet, "/caches/CACHE1/keys/", nil))
+		a.NoError(m.Get("CACHE1").Get("", &val, nil))
+		a.Equal(http.StatusOK, do(http.MethodGet, "/caches/CACHE1/keys/", &ki))
+		a.Equal("", ki.Key)
+	})
+	t.Run("Keys", func(t *testing.T) {
+		var page KeysPage
+		a.Equal(http.StatusOK, do(http.MethodGet, "/caches/CACHE1/keys?count=100", &page))
+		a.ElementsMatch([]string{"a b", ""}, page.Keys)
+		a.Equal(uint64(0), page.Cursor)
+		a.Equal(http.StatusOK, do(http.MethodGet, "/caches/CACHE2/keys", &page))
+		a.Equal([]string{}, page.Keys)
+		a.Equal(http.StatusBadRequest, do(http.MethodGet, "/caches/CACHE1/keys?cursor=x", nil))
+		a.Equal(http.StatusNotFound, do(http.MethodGet, "/caches/CACHE3/keys", nil))
+	})
+	t.Run("Refresh", func(t *testing.T) {
+		a.Equal(http.StatusOK, do(http.MethodPost, "/caches/CACHE1/keys/c/refresh", nil))
+		a.Equal(3, fetches)
+		a.Equal(http.StatusOK, do(http.MethodGet, "/caches/CACHE1/keys/c", nil))
+		a.Equal(http.StatusNotImplemented, do(http.MethodPost, "/caches/CACHE2/keys/c/refresh", nil))
+	})
+	t.Run("Invalidate", func(t *testing.T) {
+		a.Equal(http.StatusMethodNotAllowed, do(http.MethodGet, "/caches/CACHE1/keys/c/invalidate", nil))
+		a.Equal(http.StatusOK, do(http.MethodPost, "/caches/CACHE1/keys/c/invalidate", nil))
+		a.Equal(http.StatusNotFound, do(http.MethodGet, "/caches/CACHE1/keys/c", nil))
+		a.Equal(http.StatusOK, do(http.MethodGet, "/caches/CACHE1/keys/a%20b", nil))
+		a.Equal(http.StatusOK, do(http.MethodPost, "/tags/tag1/invalidate", nil))
+		a.Equal(http.StatusNotFound, do(http.MethodGet, "/caches/CACHE1/keys/a%20b", nil))
+
+		a.NoError(m.Get("CACHE1").Get("a", &val, nil))
+		a.Equal(http.StatusOK, do(http.MethodPost, "/caches/CACHE1/invalidate", nil))
+		a.Equal(http.StatusNotFound, do(http.MethodGet, "/caches/CACHE1/keys/a", nil))
+	})
+}
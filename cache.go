@@ -24,6 +24,15 @@ package cachery
 import (
 	"expvar"
 	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrScanNotSupported driver wrapped by another one doesn't implement Scanner
+	ErrScanNotSupported = errors.New("cachery: driver doesn't support scanning")
+	// ErrEventsNotSupported driver wrapped by another one doesn't implement Notifier
+	ErrEventsNotSupported = errors.New("cachery: driver doesn't support events")
 )
 
 // Fetcher is a function which returns data from origin data store
@@ -43,6 +52,20 @@ type Cache interface {
 	InvalidateAll()
 }
 
+// Tagger is implemented by caches which expose their tags, Manager uses it to index caches by tags
+type Tagger interface {
+	// Tags returns tags of the cache
+	Tags() []string
+}
+
+// TagBroadcaster delivers tags invalidation to peer processes
+type TagBroadcaster interface {
+	// BroadcastTags sends tags invalidation to peers
+	BroadcastTags(tags ...string) error
+	// SubscribeTags registers function which is called on tags invalidation received from peers
+	SubscribeTags(f func(tags ...string)) error
+}
+
 // Driver describes storage driver interface
 type Driver interface {
 	// Get loads key from the cache store if it is not outdated
@@ -55,6 +78,76 @@ type Driver interface {
 	InvalidateAll(cacheName string)
 }
 
+// Scanner is implemented by drivers which can enumerate keys of the cache
+type Scanner interface {
+	// Scan returns about count keys of the cache starting from cursor and cursor of the next call.
+	// Iteration starts with zero cursor and is complete when zero cursor is returned.
+	// Keys which exist during the whole iteration are returned at least once, keys are in form of Key function.
+	Scan(cacheName string, cursor uint64, count int) (keys []string, next uint64, err error)
+}
+
+// EventType kind of change of the key in the driver
+type EventType int
+
+const (
+	// EventSet key is saved
+	EventSet EventType = iota + 1
+	// EventInvalidated key is removed by Invalidate
+	EventInvalidated
+	// EventInvalidatedAll all keys of the cache are removed by InvalidateAll, Key of the event is empty
+	EventInvalidatedAll
+	// EventExpired key is removed because its TTL is over
+	EventExpired
+	// EventEvicted key is removed because of capacity limits
+	EventEvicted
+)
+
+var eventTypeNames = map[EventType]string{
+	EventSet:            "set",
+	EventInvalidated:    "invalidated",
+	EventInvalidatedAll: "invalidated_all",
+	EventExpired:        "expired",
+	EventEvicted:        "evicted",
+}
+
+// String returns name of the event type
+func (t EventType) String() string {
+	if name, ok := eventTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Event describes change of the key in the driver, Key is in form of Key function
+type Event struct {
+	Type      EventType
+	CacheName string
+	Key       string
+}
+
+// Notifier is implemented by drivers which report changes of keys
+type Notifier interface {
+	// Subscribe registers function which is called on events of all caches, it must not block.
+	// Returned function removes the subscription
+	Subscribe(f func(e Event)) (unsubscribe func(), err error)
+}
+
+// DecodeErrorPolicy describes what DefaultCache does with entries which cannot be deserialized
+type DecodeErrorPolicy int
+
+const (
+	// DecodeErrorDefault is DecodeErrorRefetch
+	DecodeErrorDefault DecodeErrorPolicy = iota
+	// DecodeErrorRefetch refetches and replaces entries on any deserialization error,
+	// the error is returned only if the refetched entry fails too
+	DecodeErrorRefetch
+	// DecodeErrorReturn returns deserialization errors to the caller and keeps entries in the cache store
+	DecodeErrorReturn
+)
+
+// QuarantineFunc receives raw value of the entry which cannot be deserialized, e.g. to save it for debugging
+type QuarantineFunc func(cacheName string, key interface{}, val []byte, err error)
+
 // Config describes configuration of cache
 type Config struct {
 	// Expire when data in cache becomes stale but still usable and needs to be updated from fetcher
@@ -67,6 +160,21 @@ type Config struct {
 	Serializer Serializer
 	// Driver cache storage driver (e.g. Redis, Memcached, Memory)
 	Driver Driver
+	// KeyTransformer optional transformation of keys before they reach Driver, e.g. hashing of long keys
+	KeyTransformer KeyTransformer
+	// CheckKeyCollisions stores original key alongside the value and treats values of other keys as missing,
+	// it's used with KeyTransformer only
+	CheckKeyCollisions bool
+	// DecodeErrorPolicy what to do with entries which cannot be deserialized
+	DecodeErrorPolicy DecodeErrorPolicy
+	// Quarantine optional function which receives entries which cannot be deserialized
+	Quarantine QuarantineFunc
+	// ObjectMode stores values returned by fetcher without Serializer if Driver implements ObjectDriver,
+	// Serializer is used otherwise, e.g. with KeyTransformer
+	ObjectMode ObjectMode
+	// OnEvent optional function which receives events of this cache if Driver implements Notifier,
+	// keys of events are transformed by KeyTransformer
+	OnEvent func(e Event)
 	// Fetcher optional instance of Fetcher function, could be nil if fetcher parameter of Get function is used
 	Fetcher Fetcher
 	// Driver cache storage driver (e.g. Redis, Memcached, Memory)
@@ -0,0 +1,365 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DLag/cachery"
+	"github.com/DLag/cachery/drivers/redis"
+	redigo "github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+var errUsage = errors.New("wrong usage")
+
+// scanCount number of elements requested by every SCAN and SSCAN call
+const scanCount = 1000
+
+type cli struct {
+	pool       *redigo.Pool
+	plain      *redis.Driver
+	versioned  *redis.Driver
+	serializer cachery.Serializer
+	out        io.Writer
+}
+
+// value is output of get command
+type value struct {
+	Cache string      `json:"cache"`
+	Key   string      `json:"key"`
+	TTL   string      `json:"ttl"`
+	Size  int         `json:"size"`
+	Value interface{} `json:"value,omitempty"`
+	// Raw is set if value cannot be decoded
+	Raw   []byte `json:"raw,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func (c *cli) run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	cmd, args := args[0], args[1:]
+	switch {
+	case cmd == "caches" && len(args) == 0:
+		return c.caches()
+	case cmd == "count" && len(args) == 1:
+		return c.count(args[0])
+	case cmd == "keys" && len(args) == 1:
+		return c.keys(args[0])
+	case cmd == "ttl" && len(args) == 2:
+		return c.ttl(args[0], args[1])
+	case cmd == "get" && len(args) == 2:
+		return c.get(args[0], args[1])
+	case cmd == "invalidate" && len(args) == 2:
+		return c.invalidate(args[0], args[1])
+	case cmd == "invalidate-all" && len(args) == 1:
+		d, err := c.driver(args[0])
+		if err != nil {
+			return err
+		}
+		d.InvalidateAll(args[0])
+		return c.ok()
+	case cmd == "orphans":
+		return c.orphans(args)
+	}
+	return errUsage
+}
+
+// driver returns versioned driver if the cache has generation counter and plain driver otherwise
+func (c *cli) driver(cacheName string) (*redis.Driver, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	versioned, err := redigo.Bool(conn.Do("EXISTS", cacheName+redis.GenerationSuffix))
+	if err != nil {
+		return nil, err
+	}
+	if versioned {
+		return c.versioned, nil
+	}
+	return c.plain, nil
+}
+
+// namespace returns the set which lists keys of the cache
+func (c *cli) namespace(cacheName string) (string, error) {
+	d, err := c.driver(cacheName)
+	if err != nil {
+		return "", err
+	}
+	return d.Namespace(cacheName)
+}
+
+// caches finds sets which members are prefixed with the name of the set,
+// sets of versioned caches are reported only for current generation
+func (c *cli) caches() error {
+	conn := c.pool.Get()
+	defer conn.Close()
+	counts := make(map[string]int64)
+	err := scan(conn, "SCAN", nil, func(keys []string) error {
+		for _, k := range keys {
+			if err := conn.Send("TYPE", k); err != nil {
+				return err
+			}
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+		var sets []string
+		for _, k := range keys {
+			t, err := redigo.String(conn.Receive())
+			if err != nil {
+				return err
+			}
+			if t == "set" {
+				sets = append(sets, k)
+			}
+		}
+		for _, k := range sets {
+			m, err := redigo.String(conn.Do("SRANDMEMBER", k))
+			if err != nil || !strings.HasPrefix(m, k+":") {
+				continue
+			}
+			name, ok := cacheFromSet(k, func(name string) (string, bool) {
+				g, err := redigo.String(conn.Do("GET", name+redis.GenerationSuffix))
+				return g, err == nil
+			})
+			if !ok {
+				continue
+			}
+			if counts[name], err = redigo.Int64(conn.Do("SCARD", k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(c.out, "%s\t%d\n", name, counts[name])
+	}
+	return nil
+}
+
+func (c *cli) count(cacheName string) error {
+	ns, err := c.namespace(cacheName)
+	if err != nil {
+		return err
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+	n, err := redigo.Int64(conn.Do("SCARD", ns))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, n)
+	return nil
+}
+
+func (c *cli) keys(cacheName string) error {
+	ns, err := c.namespace(cacheName)
+	if err != nil {
+		return err
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+	return scan(conn, "SSCAN", []interface{}{ns}, func(members []string) error {
+		for _, m := range members {
+			fmt.Fprintln(c.out, keyFromMember(ns, m))
+		}
+		return nil
+	})
+}
+
+func (c *cli) ttl(cacheName, key string) error {
+	d, err := c.driver(cacheName)
+	if err != nil {
+		return err
+	}
+	_, ttl, err := d.Get(cacheName, key)
+	if err != nil {
+		return notFound(err)
+	}
+	fmt.Fprintln(c.out, ttl)
+	return nil
+}
+
+func (c *cli) get(cacheName, key string) error {
+	d, err := c.driver(cacheName)
+	if err != nil {
+		return err
+	}
+	val, ttl, err := d.Get(cacheName, key)
+	if err != nil {
+		return notFound(err)
+	}
+	v := decode(c.serializer, val)
+	v.Cache = cacheName
+	v.Key = key
+	v.TTL = ttl.String()
+	e := json.NewEncoder(c.out)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
+
+func (c *cli) invalidate(cacheName, key string) error {
+	d, err := c.driver(cacheName)
+	if err != nil {
+		return err
+	}
+	if err = d.Invalidate(cacheName, key); err != nil {
+		return err
+	}
+	return c.ok()
+}
+
+func (c *cli) orphans(args []string) error {
+	flags := flag.NewFlagSet("orphans", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	del := flags.Bool("delete", false, "remove orphaned members from the cache set")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+	ns, err := c.namespace(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+	var orphans []string
+	err = scan(conn, "SSCAN", []interface{}{ns}, func(members []string) error {
+		for _, m := range members {
+			if err := conn.Send("EXISTS", m); err != nil {
+				return err
+			}
+		}
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+		for _, m := range members {
+			exists, err := redigo.Bool(conn.Receive())
+			if err != nil {
+				return err
+			}
+			if !exists {
+				orphans = append(orphans, m)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, m := range orphans {
+		fmt.Fprintln(c.out, keyFromMember(ns, m))
+		if *del {
+			if _, err = conn.Do("SREM", ns, m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *cli) ok() error {
+	_, err := fmt.Fprintln(c.out, "OK")
+	return err
+}
+
+// scan iterates over SCAN-like command calling f for every batch
+func scan(conn redigo.Conn, cmd string, args []interface{}, f func([]string) error) error {
+	cursor := 0
+	for {
+		params := append(append([]interface{}{}, args...), cursor, "COUNT", scanCount)
+		reply, err := redigo.Values(conn.Do(cmd, params...))
+		if err != nil {
+			return err
+		}
+		var batch []string
+		if _, err = redigo.Scan(reply, &cursor, &batch); err != nil {
+			return err
+		}
+		if err = f(batch); err != nil {
+			return err
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// keyFromMember strips namespace of the cache from member of the cache set
+func keyFromMember(ns, member string) string {
+	return strings.TrimPrefix(member, ns+":")
+}
+
+// cacheFromSet returns name of the cache which keys are listed in the set,
+// sets of versioned caches are named "cacheName:generation" and only current generation is reported.
+// The set is versioned only if generation counter of the cache exists, so plain caches like "orders:2024" are kept.
+func cacheFromSet(set string, generation func(cacheName string) (string, bool)) (string, bool) {
+	i := strings.LastIndex(set, ":")
+	if i < 0 {
+		return set, true
+	}
+	name, g := set[:i], set[i+1:]
+	if _, err := strconv.ParseUint(g, 10, 64); err != nil {
+		return set, true
+	}
+	current, ok := generation(name)
+	if !ok {
+		return set, true
+	}
+	return name, current == g
+}
+
+// decode deserializes value to generic type, raw value is returned if it's impossible
+func decode(s cachery.Serializer, val []byte) value {
+	v := value{Size: len(val)}
+	var obj interface{}
+	if err := s.Deserialize(val, &obj); err != nil {
+		v.Raw = val
+		v.Error = err.Error()
+		return v
+	}
+	v.Value = obj
+	return v
+}
+
+func notFound(err error) error {
+	if err == redigo.ErrNil {
+		return errors.New("key not found")
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/DLag/cachery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyFromMember(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("key", keyFromMember("cache", "cache:key"))
+	a.Equal("key:with:colons", keyFromMember("cache", "cache:key:with:colons"))
+	a.Equal("other:key", keyFromMember("cache", "other:key"))
+}
+
+func TestCacheFromSet(t *testing.T) {
+	a := assert.New(t)
+	generations := map[string]string{"versioned": "3", "fresh": "0"}
+	generation := func(name string) (string, bool) {
+		g, ok := generations[name]
+		return g, ok
+	}
+	tt := []struct {
+		set  string
+		name string
+		ok   bool
+	}{
+		{"cache", "cache", true},
+		{"cache:name", "cache:name", true},
+		{"versioned:3", "versioned", true},
+		{"versioned:2", "versioned", false},
+		{"fresh:0", "fresh", true},
+		{"fresh:1", "fresh", false},
+		{"orders:2024", "orders:2024", true},
+	}
+	for _, tc := range tt {
+		name, ok := cacheFromSet(tc.set, generation)
+		a.Equal(tc.name, name, tc.set)
+		a.Equal(tc.ok, ok, tc.set)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	a := assert.New(t)
+	val, _ := new(cachery.JSONSerializer).Serialize(map[string]int{"a": 1})
+	v := decode(new(cachery.JSONSerializer), val)
+	a.Equal(map[string]interface{}{"a": float64(1)}, v.Value)
+	a.Equal(len(val), v.Size)
+	a.Empty(v.Raw)
+	a.Empty(v.Error)
+
+	val, _ = new(cachery.GobSerializer).Serialize(1)
+	v = decode(new(cachery.GobSerializer), val)
+	a.Nil(v.Value)
+	a.Equal(val, v.Raw)
+	a.NotEmpty(v.Error)
+}
+
+func TestCLI_Usage(t *testing.T) {
+	a := assert.New(t)
+	c := &cli{out: new(bytes.Buffer)}
+	a.Equal(errUsage, c.run(nil))
+	a.Equal(errUsage, c.run([]string{"get", "cache"}))
+	a.Equal(errUsage, c.run([]string{"orphans"}))
+	a.Equal(errUsage, c.run([]string{"orphans", "-unknown", "cache"}))
+	a.Equal(errUsage, c.run([]string{"unknown"}))
+}
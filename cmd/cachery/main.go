@@ -0,0 +1,98 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/DLag/cachery"
+	"github.com/DLag/cachery/drivers/redis"
+	redigo "github.com/garyburd/redigo/redis"
+)
+
+const usage = `Usage: cachery [flags] <command> [arguments]
+
+Inspects caches stored in Redis by github.com/DLag/cachery/drivers/redis.
+Caches with generation counter are handled as caches of versioned driver.
+
+Commands:
+  caches                      list caches with number of keys
+  count <cache>               number of keys in the cache
+  keys <cache>                list keys of the cache
+  ttl <cache> <key>           time to live of the key
+  get <cache> <key>           value of the key decoded with -serializer and printed as JSON
+  invalidate <cache> <key>    remove the key
+  invalidate-all <cache>      remove all keys of the cache
+  orphans [-delete] <cache>   list (and remove) keys which are in the cache set but already expired
+
+Flags:
+`
+
+var serializers = map[string]cachery.Serializer{
+	"gob":  new(cachery.GobSerializer),
+	"json": new(cachery.JSONSerializer),
+}
+
+func main() {
+	addr := flag.String("redis", "127.0.0.1:6379", "address of Redis server")
+	db := flag.Int("db", 0, "Redis database")
+	password := flag.String("password", "", "Redis password")
+	serializer := flag.String("serializer", "gob", "serializer of values: gob or json")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	s, ok := serializers[*serializer]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "cachery: unknown serializer %q\n", *serializer)
+		os.Exit(2)
+	}
+	pool := &redigo.Pool{
+		MaxIdle:     1,
+		IdleTimeout: time.Minute,
+		Dial: func() (redigo.Conn, error) {
+			return redigo.Dial("tcp", *addr, redigo.DialDatabase(*db), redigo.DialPassword(*password))
+		},
+	}
+	defer pool.Close()
+
+	c := &cli{
+		pool:       pool,
+		plain:      redis.New(pool),
+		versioned:  redis.NewVersioned(pool),
+		serializer: s,
+		out:        os.Stdout,
+	}
+	if err := c.run(flag.Args()); err != nil {
+		if err == errUsage {
+			flag.Usage()
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "cachery:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cachery
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"expvar"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Header bytes of CompressSerializer payloads
+const (
+	// HeaderRaw payload isn't compressed
+	HeaderRaw byte = 0
+	// HeaderGzip payload is compressed by GzipCodec
+	HeaderGzip byte = 1
+	// HeaderFlate payload is compressed by FlateCodec
+	HeaderFlate byte = 2
+)
+
+var (
+	// ErrEmptyPayload payload doesn't have header byte
+	ErrEmptyPayload = errors.New("cachery: payload is empty")
+	// ErrUnknownCodec header byte of payload doesn't match any codec
+	ErrUnknownCodec = errors.New("cachery: unknown compression codec")
+)
+
+// Codec compresses payloads of CompressSerializer
+type Codec interface {
+	// Header is written before compressed payload, it must be unique and not equal to HeaderRaw
+	Header() byte
+	// Compress returns compressed src
+	Compress(src []byte) ([]byte, error)
+	// Decompress returns decompressed src
+	Decompress(src []byte) ([]byte, error)
+}
+
+// GzipCodec implements Codec with gzip compression
+type GzipCodec struct {
+	// Level of compression, gzip.DefaultCompression if zero
+	Level int
+}
+
+// Header returns HeaderGzip
+func (GzipCodec) Header() byte {
+	return HeaderGzip
+}
+
+// Compress returns compressed src
+func (c GzipCodec) Compress(src []byte) ([]byte, error) {
+	return compress(gzipWriters, c.Level, src)
+}
+
+// Decompress returns decompressed src
+func (GzipCodec) Decompress(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// FlateCodec implements Codec with DEFLATE compression, it's faster than gzip and has smaller header
+type FlateCodec struct {
+	// Level of compression, flate.DefaultCompression if zero
+	Level int
+}
+
+// Header returns HeaderFlate
+func (FlateCodec) Header() byte {
+	return HeaderFlate
+}
+
+// Compress returns compressed src
+func (c FlateCodec) Compress(src []byte) ([]byte, error) {
+	return compress(flateWriters, c.Level, src)
+}
+
+// Decompress returns decompressed src
+func (FlateCodec) Decompress(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func level(l int) int {
+	if l == 0 {
+		return flate.DefaultCompression
+	}
+	return l
+}
+
+// compressWriter is implemented by gzip.Writer and flate.Writer
+type compressWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// writerPools keeps pools of compressors by compression level, their allocation is expensive
+type writerPools struct {
+	create func(level int) (compressWriter, error)
+	pools  map[int]*sync.Pool
+	lock   sync.Mutex
+}
+
+var (
+	gzipWriters = &writerPools{
+		create: func(level int) (compressWriter, error) {
+			return gzip.NewWriterLevel(ioutil.Discard, level)
+		},
+		pools: make(map[int]*sync.Pool),
+	}
+	flateWriters = &writerPools{
+		create: func(level int) (compressWriter, error) {
+			return flate.NewWriter(ioutil.Discard, level)
+		},
+		pools: make(map[int]*sync.Pool),
+	}
+)
+
+func (p *writerPools) get(level int) (compressWriter, *sync.Pool, error) {
+	p.lock.Lock()
+	pool, ok := p.pools[level]
+	if !ok {
+		pool = new(sync.Pool)
+		p.pools[level] = pool
+	}
+	p.lock.Unlock()
+	if w, ok := pool.Get().(compressWriter); ok {
+		return w, pool, nil
+	}
+	w, err := p.create(level)
+	return w, pool, err
+}
+
+func compress(pools *writerPools, l int, src []byte) ([]byte, error) {
+	w, pool, err := pools.get(level(l))
+	if err != nil {
+		return nil, err
+	}
+	b := getBuffer()
+	defer putBuffer(b)
+	w.Reset(b)
+	if _, err = w.Write(src); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	pool.Put(w)
+	return append([]byte(nil), b.Bytes()...), nil
+}
+
+// CompressSerializer wraps Serializer and compresses payloads which are not shorter than threshold.
+// Every payload starts with header byte, so compressed and raw payloads could be mixed in the cache store.
+type CompressSerializer struct {
+	serializer Serializer
+	threshold  int
+	codec      Codec
+	codecs     map[byte]Codec
+	stats      *expvar.Map
+	// Expvar optional map where compression statistics are published as well
+	Expvar *expvar.Map
+	// statsLock keeps counters of bytes consistent for Ratio
+	statsLock sync.Mutex
+}
+
+// NewCompressSerializer creates an instance of CompressSerializer,
+// the first codec is used for compression and all of them for decompression,
+// GzipCodec is used if codecs aren't set, payloads of GzipCodec and FlateCodec are always readable
+func NewCompressSerializer(serializer Serializer, threshold int, codecs ...Codec) *CompressSerializer {
+	s := &CompressSerializer{
+		serializer: serializer,
+		threshold:  threshold,
+		codecs: map[byte]Codec{
+			HeaderGzip:  GzipCodec{},
+			HeaderFlate: FlateCodec{},
+		},
+		stats: new(expvar.Map).Init(),
+	}
+	for _, c := range codecs {
+		s.codecs[c.Header()] = c
+	}
+	if len(codecs) > 0 {
+		s.codec = codecs[0]
+	} else {
+		s.codec = s.codecs[HeaderGzip]
+	}
+	return s
+}
+
+// Serialize serializes object and compresses it if it's not shorter than threshold
+func (s *CompressSerializer) Serialize(obj interface{}) ([]byte, error) {
+	raw, err := s.serializer.Serialize(obj)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) >= s.threshold {
+		compressed, err := s.codec.Compress(raw)
+		if err != nil {
+			s.add("compress_errors", 1)
+			return nil, errors.Wrap(err, "cachery: cannot compress payload")
+		}
+		// Compression of small or random payloads could make them bigger
+		if len(compressed) < len(raw) {
+			s.statsLock.Lock()
+			s.add("compressed", 1)
+			s.add("raw_bytes", int64(len(raw)))
+			s.add("compressed_bytes", int64(len(compressed)))
+			s.statsLock.Unlock()
+			return append([]byte{s.codec.Header()}, compressed...), nil
+		}
+	}
+	s.add("uncompressed", 1)
+	return append([]byte{HeaderRaw}, raw...), nil
+}
+
+// Deserialize decompresses payload if necessary and deserializes it to object
+func (s *CompressSerializer) Deserialize(src []byte, obj interface{}) error {
+	if len(src) == 0 {
+		return ErrEmptyPayload
+	}
+	payload := src[1:]
+	if src[0] != HeaderRaw {
+		c, ok := s.codecs[src[0]]
+		if !ok {
+			return errors.Wrapf(ErrUnknownCodec, "header %d", src[0])
+		}
+		var err error
+		if payload, err = c.Decompress(payload); err != nil {
+			s.add("decompress_errors", 1)
+			return errors.Wrap(err, "cachery: cannot decompress payload")
+		}
+	}
+	return s.serializer.Deserialize(payload, obj)
+}
+
+// Stats returns counters of compressed and uncompressed payloads and their sizes
+func (s *CompressSerializer) Stats() map[string]int64 {
+	stats := make(map[string]int64)
+	s.stats.Do(func(kv expvar.KeyValue) {
+		if v, ok := kv.Value.(*expvar.Int); ok {
+			stats[kv.Key] = v.Value()
+		}
+	})
+	return stats
+}
+
+// Ratio returns ratio of compressed size to raw size of compressed payloads, 1 if nothing is compressed yet
+func (s *CompressSerializer) Ratio() float64 {
+	s.statsLock.Lock()
+	stats := s.Stats()
+	s.statsLock.Unlock()
+	if stats["raw_bytes"] == 0 {
+		return 1
+	}
+	return float64(stats["compressed_bytes"]) / float64(stats["raw_bytes"])
+}
+
+func (s *CompressSerializer) add(key string, delta int64) {
+	s.stats.Add(key, delta)
+	if s.Expvar != nil {
+		s.Expvar.Add(key, delta)
+	}
+}
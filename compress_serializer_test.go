@@ -0,0 +1,85 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cachery
+
+import (
+	"compress/flate"
+	"expvar"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressSerializer(t *testing.T) {
+	a := assert.New(t)
+	long := strings.Repeat("compressible ", 100)
+
+	for name, codec := range map[string]Codec{
+		"Gzip":  GzipCodec{},
+		"Flate": FlateCodec{Level: flate.BestSpeed},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := NewCompressSerializer(new(JSONSerializer), 64, codec)
+			s.Expvar = new(expvar.Map).Init()
+
+			buf, err := s.Serialize(long)
+			a.NoError(err)
+			a.Equal(codec.Header(), buf[0])
+			a.True(len(buf) < len(long))
+			var dst string
+			a.NoError(s.Deserialize(buf, &dst))
+			a.Equal(long, dst)
+
+			buf, err = s.Serialize("short")
+			a.NoError(err)
+			a.Equal(HeaderRaw, buf[0])
+			a.Equal(`"short"`, string(buf[1:]))
+			a.NoError(s.Deserialize(buf, &dst))
+			a.Equal("short", dst)
+
+			stats := s.Stats()
+			a.Equal(int64(1), stats["compressed"])
+			a.Equal(int64(1), stats["uncompressed"])
+			a.Equal(int64(len(long)+2), stats["raw_bytes"])
+			a.Len(buf, len(`"short"`)+1)
+			a.True(s.Ratio() < 0.1)
+			a.Equal("1", s.Expvar.Get("compressed").String())
+		})
+	}
+
+	t.Run("Mixed", func(t *testing.T) {
+		gz := NewCompressSerializer(new(JSONSerializer), 0)
+		fl := NewCompressSerializer(new(JSONSerializer), 0, FlateCodec{})
+		a.Equal(float64(1), fl.Ratio())
+		buf, err := gz.Serialize(long)
+		a.NoError(err)
+		var dst string
+		a.NoError(fl.Deserialize(buf, &dst))
+		a.Equal(long, dst)
+
+		a.Equal(ErrEmptyPayload, fl.Deserialize(nil, &dst))
+		a.Error(fl.Deserialize([]byte{42, 1}, &dst))
+		a.Error(fl.Deserialize([]byte{HeaderGzip, 1}, &dst))
+		a.Equal(int64(1), fl.Stats()["decompress_errors"])
+	})
+}
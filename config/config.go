@@ -0,0 +1,232 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultEnvPrefix prefix of environment variables which override cache parameters
+const DefaultEnvPrefix = "CACHERY"
+
+var (
+	// ErrNoName cache description doesn't have a name
+	ErrNoName = errors.New("cachery/config: cache name is empty")
+	// ErrDuplicateName cache description has the same name as another one
+	ErrDuplicateName = errors.New("cachery/config: duplicate cache name")
+	// ErrBadLifetime cache description has Lifetime shorter than Expire or not set at all
+	ErrBadLifetime = errors.New("cachery/config: Lifetime must be positive and not shorter than Expire")
+)
+
+// Spec describes set of caches
+type Spec struct {
+	// Caches descriptions of the caches
+	Caches []CacheSpec `json:"caches" yaml:"caches"`
+}
+
+// CacheSpec describes single cache, it mirrors cachery.Config
+// but refers to drivers, serializers and fetchers by their names in Registry
+type CacheSpec struct {
+	// Name of the cache
+	Name string `json:"name" yaml:"name"`
+	// Expire when data in cache becomes stale but still usable and needs to be updated from fetcher
+	Expire Duration `json:"expire" yaml:"expire"`
+	// Lifetime when data in cache becomes outdated and needs to be updated from fetcher before use
+	Lifetime Duration `json:"lifetime" yaml:"lifetime"`
+	// Tags of the cache
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// Serializer name of the serializer in Registry
+	Serializer string `json:"serializer" yaml:"serializer"`
+	// Driver name of the driver in Registry
+	Driver string `json:"driver" yaml:"driver"`
+	// Fetcher optional name of the fetcher in Registry
+	Fetcher string `json:"fetcher,omitempty" yaml:"fetcher,omitempty"`
+	// Expvar optional name of expvar.Map for cache statistics, it will be created if doesn't exist
+	Expvar string `json:"expvar,omitempty" yaml:"expvar,omitempty"`
+}
+
+// Duration is time.Duration which could be parsed from strings like "1m30s"
+type Duration time.Duration
+
+// UnmarshalJSON parses duration from string or from number of nanoseconds
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(b, &n); err != nil {
+			return errors.Wrap(err, "cachery/config: cannot parse duration")
+		}
+		*d = Duration(n)
+		return nil
+	}
+	return d.parse(s)
+}
+
+// UnmarshalYAML parses duration from string or from number of nanoseconds
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var n int64
+	if err := unmarshal(&n); err == nil {
+		*d = Duration(n)
+		return nil
+	}
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return errors.Wrap(err, "cachery/config: cannot parse duration")
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.Wrap(err, "cachery/config: cannot parse duration")
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ParseJSON parses JSON description of caches, unknown fields are rejected as in ParseYAML
+func ParseJSON(data []byte) (*Spec, error) {
+	spec := new(Spec)
+	if err := unmarshalJSONStrict(data, spec); err != nil {
+		return nil, errors.Wrap(err, "cachery/config: cannot parse JSON")
+	}
+	return spec, nil
+}
+
+// decodeSingle decodes the only JSON value, trailing data is an error as in json.Unmarshal
+func decodeSingle(d *json.Decoder, v interface{}) error {
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return errors.New("unexpected data after JSON value")
+	}
+	return nil
+}
+
+// ParseYAML parses YAML description of caches
+func ParseYAML(data []byte) (*Spec, error) {
+	spec := new(Spec)
+	if err := yaml.UnmarshalStrict(data, spec); err != nil {
+		return nil, errors.Wrap(err, "cachery/config: cannot parse YAML")
+	}
+	return spec, nil
+}
+
+// ReadFile reads description of caches from file,
+// files with .json extension are parsed as JSON and all others as YAML
+func ReadFile(path string) (*Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "cachery/config: cannot read file")
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return ParseJSON(data)
+	}
+	return ParseYAML(data)
+}
+
+// ApplyEnv overrides parameters of caches with environment variables
+// named PREFIX_CACHE_PARAMETER (e.g. CACHERY_ORDERS_LIFETIME=10m).
+// Cache name is upper-cased and every character except letters and digits is replaced with underscore.
+// Supported parameters are EXPIRE, LIFETIME, TAGS (comma-separated), SERIALIZER, DRIVER, FETCHER and EXPVAR.
+func (s *Spec) ApplyEnv(prefix string) error {
+	return s.applyEnv(prefix, os.LookupEnv)
+}
+
+func (s *Spec) applyEnv(prefix string, lookup func(string) (string, bool)) error {
+	for i := range s.Caches {
+		c := &s.Caches[i]
+		name := envName(prefix) + "_" + envName(c.Name) + "_"
+		durations := map[string]*Duration{
+			"EXPIRE":   &c.Expire,
+			"LIFETIME": &c.Lifetime,
+		}
+		for param, d := range durations {
+			if v, ok := lookup(name + param); ok {
+				if err := d.parse(v); err != nil {
+					return errors.Wrap(err, name+param)
+				}
+			}
+		}
+		strs := map[string]*string{
+			"SERIALIZER": &c.Serializer,
+			"DRIVER":     &c.Driver,
+			"FETCHER":    &c.Fetcher,
+			"EXPVAR":     &c.Expvar,
+		}
+		for param, str := range strs {
+			if v, ok := lookup(name + param); ok {
+				*str = v
+			}
+		}
+		if v, ok := lookup(name + "TAGS"); ok {
+			c.Tags = nil
+			for _, t := range strings.Split(v, ",") {
+				if t = strings.TrimSpace(t); t != "" {
+					c.Tags = append(c.Tags, t)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks that description of caches is consistent
+func (s *Spec) Validate() error {
+	names := make(map[string]struct{}, len(s.Caches))
+	for _, c := range s.Caches {
+		if c.Name == "" {
+			return ErrNoName
+		}
+		if _, ok := names[c.Name]; ok {
+			return errors.Wrap(ErrDuplicateName, c.Name)
+		}
+		names[c.Name] = struct{}{}
+		if c.Lifetime <= 0 || c.Lifetime < c.Expire {
+			return errors.Wrap(ErrBadLifetime, c.Name)
+		}
+	}
+	return nil
+}
+
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DLag/cachery"
+	"github.com/DLag/cachery/drivers/mock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const specYAML = `
+caches:
+  - name: orders
+    expire: 20s
+    lifetime: 30s
+    tags: [orders, goods]
+    serializer: gob
+    driver: memory
+    fetcher: orders
+    expvar: cachery-config-test
+  - name: goods
+    expire: 10m
+    lifetime: 1h
+    tags: [goods]
+    serializer: json
+    driver: memory
+`
+
+const specJSON = `{
+  "caches": [
+    {"name": "orders", "expire": "20s", "lifetime": "30s", "tags": ["orders", "goods"],
+     "serializer": "gob", "driver": "memory", "fetcher": "orders", "expvar": "cachery-config-test"},
+    {"name": "goods", "expire": "10m", "lifetime": 3600000000000, "tags": ["goods"],
+     "serializer": "json", "driver": "memory"}
+  ]
+}`
+
+func TestParse(t *testing.T) {
+	a := assert.New(t)
+	expected := &Spec{Caches: []CacheSpec{
+		{
+			Name:       "orders",
+			Expire:     Duration(20 * time.Second),
+			Lifetime:   Duration(30 * time.Second),
+			Tags:       []string{"orders", "goods"},
+			Serializer: "gob",
+			Driver:     "memory",
+			Fetcher:    "orders",
+			Expvar:     "cachery-config-test",
+		},
+		{
+			Name:       "goods",
+			Expire:     Duration(10 * time.Minute),
+			Lifetime:   Duration(time.Hour),
+			Tags:       []string{"goods"},
+			Serializer: "json",
+			Driver:     "memory",
+		},
+	}}
+
+	spec, err := ParseYAML([]byte(specYAML))
+	a.NoError(err)
+	a.Equal(expected, spec)
+
+	spec, err = ParseJSON([]byte(specJSON))
+	a.NoError(err)
+	a.Equal(expected, spec)
+
+	_, err = ParseYAML([]byte("caches:\n  - name: a\n    expire: forever\n"))
+	a.Error(err)
+	_, err = ParseYAML([]byte("caches:\n  - name: a\n    unknown: field\n"))
+	a.Error(err)
+	_, err = ParseJSON([]byte(`{"caches": [{"name": "a", "unknown": "field"}]}`))
+	a.Error(err)
+	_, err = ParseJSON([]byte(`{"caches": [{"name": "a", "expire": "forever"}]}`))
+	a.Error(err)
+	_, err = ParseJSON([]byte(`{"caches": []} {}`))
+	a.Error(err)
+}
+
+func TestReadFile(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "cachery-config")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string]string{"caches.yml": specYAML, "caches.json": specJSON} {
+		path := filepath.Join(dir, name)
+		a.NoError(ioutil.WriteFile(path, []byte(data), 0600))
+		spec, err := ReadFile(path)
+		a.NoError(err)
+		a.Len(spec.Caches, 2)
+	}
+	_, err = ReadFile(filepath.Join(dir, "nofile.yml"))
+	a.Error(err)
+}
+
+func TestSpec_ApplyEnv(t *testing.T) {
+	a := assert.New(t)
+	spec, err := ParseYAML([]byte(specYAML))
+	a.NoError(err)
+
+	env := map[string]string{
+		"APP_ORDERS_LIFETIME":  "1m",
+		"APP_ORDERS_TAGS":      "orders, ,users",
+		"APP_GOODS_DRIVER":     "redis",
+		"CACHERY_GOODS_EXPIRE": "1s",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	a.NoError(spec.applyEnv("app", lookup))
+	a.Equal(Duration(20*time.Second), spec.Caches[0].Expire)
+	a.Equal(Duration(time.Minute), spec.Caches[0].Lifetime)
+	a.Equal([]string{"orders", "users"}, spec.Caches[0].Tags)
+	a.Equal("redis", spec.Caches[1].Driver)
+	a.Equal(Duration(10*time.Minute), spec.Caches[1].Expire)
+
+	env["APP_GOODS_EXPIRE"] = "soon"
+	a.Error(spec.applyEnv("APP", lookup))
+}
+
+func TestSpec_Validate(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(new(Spec).Validate())
+	spec := &Spec{Caches: []CacheSpec{{Name: "a", Expire: 2, Lifetime: 1}}}
+	a.Equal(ErrBadLifetime, errors.Cause(spec.Validate()))
+	spec = &Spec{Caches: []CacheSpec{{Lifetime: 1}}}
+	a.Equal(ErrNoName, errors.Cause(spec.Validate()))
+	spec = &Spec{Caches: []CacheSpec{{Name: "a", Lifetime: 1}, {Name: "a", Lifetime: 1}}}
+	a.Equal(ErrDuplicateName, errors.Cause(spec.Validate()))
+}
+
+func TestRegistry(t *testing.T) {
+	a := assert.New(t)
+	d := new(mock.Driver)
+	fetcher := func(key interface{}) (interface{}, error) {
+		return 1, nil
+	}
+	r := NewRegistry().
+		RegisterDriver("memory", d).
+		RegisterFetcher("orders", fetcher)
+
+	spec, err := ParseYAML([]byte(specYAML))
+	a.NoError(err)
+	m, err := r.Manager(spec)
+	a.NoError(err)
+
+	c := m.Get("orders")
+	a.NotNil(c)
+	a.NotNil(m.Get("goods"))
+
+	valSerialized, _ := new(cachery.GobSerializer).Serialize(1)
+	d.On("Get", "orders", "a").
+		Return([]byte(nil), time.Duration(0), errors.New("miss")).Once()
+	d.On("Set", "orders", "a", valSerialized, time.Second*30).
+		Return(nil).Once()
+	d.On("Get", "orders", "a").
+		Return(valSerialized, time.Second*30, nil).Once()
+	var val int
+	a.NoError(c.Get("a", &val, nil))
+	a.Equal(1, val)
+	d.AssertExpectations(t)
+
+	spec.Caches[1].Driver = "redis"
+	_, err = r.Caches(spec)
+	a.Equal(ErrUnknownDriver, errors.Cause(err))
+	spec.Caches[1].Driver = "memory"
+	spec.Caches[1].Serializer = "xml"
+	_, err = r.Caches(spec)
+	a.Equal(ErrUnknownSerializer, errors.Cause(err))
+	spec.Caches[1].Serializer = "json"
+	spec.Caches[1].Fetcher = "goods"
+	_, err = r.Caches(spec)
+	a.Equal(ErrUnknownFetcher, errors.Cause(err))
+}
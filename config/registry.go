@@ -0,0 +1,150 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package config
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/DLag/cachery"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrUnknownDriver driver isn't registered in Registry
+	ErrUnknownDriver = errors.New("cachery/config: unknown driver")
+	// ErrUnknownSerializer serializer isn't registered in Registry
+	ErrUnknownSerializer = errors.New("cachery/config: unknown serializer")
+	// ErrUnknownFetcher fetcher isn't registered in Registry
+	ErrUnknownFetcher = errors.New("cachery/config: unknown fetcher")
+)
+
+// Registry keeps named drivers, serializers and fetchers which are referenced from Spec
+type Registry struct {
+	drivers     map[string]cachery.Driver
+	serializers map[string]cachery.Serializer
+	fetchers    map[string]cachery.Fetcher
+	sync.Mutex
+}
+
+// NewRegistry creates an instance of Registry with builtin "gob" and "json" serializers
+func NewRegistry() *Registry {
+	r := new(Registry)
+	r.drivers = make(map[string]cachery.Driver)
+	r.serializers = make(map[string]cachery.Serializer)
+	r.fetchers = make(map[string]cachery.Fetcher)
+	r.RegisterSerializer("gob", new(cachery.GobSerializer))
+	r.RegisterSerializer("json", new(cachery.JSONSerializer))
+	return r
+}
+
+// RegisterDriver adds driver to Registry under the name
+func (r *Registry) RegisterDriver(name string, driver cachery.Driver) *Registry {
+	r.Lock()
+	r.drivers[name] = driver
+	r.Unlock()
+	return r
+}
+
+// RegisterSerializer adds serializer to Registry under the name
+func (r *Registry) RegisterSerializer(name string, serializer cachery.Serializer) *Registry {
+	r.Lock()
+	r.serializers[name] = serializer
+	r.Unlock()
+	return r
+}
+
+// RegisterFetcher adds fetcher to Registry under the name
+func (r *Registry) RegisterFetcher(name string, fetcher cachery.Fetcher) *Registry {
+	r.Lock()
+	r.fetchers[name] = fetcher
+	r.Unlock()
+	return r
+}
+
+// Caches creates caches described in spec
+func (r *Registry) Caches(spec *Spec) ([]cachery.Cache, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	r.Lock()
+	defer r.Unlock()
+	caches := make([]cachery.Cache, 0, len(spec.Caches))
+	for _, c := range spec.Caches {
+		config := cachery.Config{
+			Expire:   time.Duration(c.Expire),
+			Lifetime: time.Duration(c.Lifetime),
+			Tags:     c.Tags,
+		}
+		var ok bool
+		if config.Driver, ok = r.drivers[c.Driver]; !ok {
+			return nil, errors.Wrapf(ErrUnknownDriver, "%q in cache %q", c.Driver, c.Name)
+		}
+		if config.Serializer, ok = r.serializers[c.Serializer]; !ok {
+			return nil, errors.Wrapf(ErrUnknownSerializer, "%q in cache %q", c.Serializer, c.Name)
+		}
+		if c.Fetcher != "" {
+			if config.Fetcher, ok = r.fetchers[c.Fetcher]; !ok {
+				return nil, errors.Wrapf(ErrUnknownFetcher, "%q in cache %q", c.Fetcher, c.Name)
+			}
+		}
+		if c.Expvar != "" {
+			config.Expvar = expvarMap(c.Expvar)
+		}
+		caches = append(caches, cachery.NewDefault(c.Name, config))
+	}
+	return caches, nil
+}
+
+// Manager creates Manager populated with caches described in spec
+func (r *Registry) Manager(spec *Spec) (*cachery.Manager, error) {
+	caches, err := r.Caches(spec)
+	if err != nil {
+		return nil, err
+	}
+	return new(cachery.Manager).Add(caches...), nil
+}
+
+// Load reads description of caches from file, applies overrides from environment variables
+// with DefaultEnvPrefix and creates caches described there
+func Load(path string, r *Registry) ([]cachery.Cache, error) {
+	spec, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = spec.ApplyEnv(DefaultEnvPrefix); err != nil {
+		return nil, err
+	}
+	return r.Caches(spec)
+}
+
+var expvarLock sync.Mutex
+
+func expvarMap(name string) *expvar.Map {
+	expvarLock.Lock()
+	defer expvarLock.Unlock()
+	if m, ok := expvar.Get(name).(*expvar.Map); ok {
+		return m
+	}
+	return expvar.NewMap(name)
+}
@@ -22,8 +22,10 @@
 package cachery
 
 import (
+	"expvar"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -39,8 +41,14 @@ var (
 type DefaultCache struct {
 	name      string
 	config    Config
+	driver    Driver
+	objects   ObjectDriver
+	stats     *expvar.Map
 	updating  int32
 	fetchLock sync.RWMutex
+	// unsubscribe removes subscription to driver events, it's set if OnEvent is used
+	unsubscribe func()
+	closeOnce   sync.Once
 }
 
 // NewDefault creates an instance of DefaultCache
@@ -48,6 +56,25 @@ func NewDefault(name string, config Config) *DefaultCache {
 	cache := new(DefaultCache)
 	cache.name = name
 	cache.config = config
+	cache.driver = config.Driver
+	if config.KeyTransformer != nil {
+		cache.driver = NewKeyDriver(config.Driver, config.KeyTransformer, config.CheckKeyCollisions)
+	}
+	if config.ObjectMode != ObjectModeOff {
+		cache.objects, _ = cache.driver.(ObjectDriver)
+	}
+	cache.stats = new(expvar.Map).Init()
+	if n, ok := config.Driver.(Notifier); ok && config.OnEvent != nil {
+		var err error
+		cache.unsubscribe, err = n.Subscribe(func(e Event) {
+			if e.CacheName == name {
+				config.OnEvent(e)
+			}
+		})
+		if err != nil {
+			cache.expvarAdd("subscribe_errors", 1)
+		}
+	}
 	return cache
 }
 
@@ -70,18 +97,38 @@ func (c *DefaultCache) Get(key interface{}, obj interface{}, fetcher Fetcher) er
 	for {
 		// Trying to get item from Redis server
 		attempts++
-		val, ttl, err := c.config.Driver.Get(c.name, key)
+		val, stored, ttl, err := c.get(key)
 		c.expvarAdd("gets", 1)
-		if err == nil {
+		// Entry of ObjectDriver which isn't an object is handled as an undecodable one
+		if err == nil || c.objects != nil && errors.Cause(err) == ErrBadEntry {
 			// Item isn't expired
-			err = c.deserialize(val, obj)
+			if err == nil {
+				err = c.decode(key, val, stored, obj)
+			}
+			if err != nil {
+				if err == ErrNilSerializer {
+					return err
+				}
+				c.expvarAdd("decode_errors", 1)
+				if c.config.Quarantine != nil {
+					c.config.Quarantine(c.name, key, val, err)
+				}
+				// Bad entry is treated as a miss
+				if c.refetchOnDecodeError() && attempts == 1 {
+					if err = c.refetch(key, obj, fetcher); err != nil {
+						return err
+					}
+					continue
+				}
+				return err
+			}
 			// If object is expired but still alive use stale value but start background update
 			if (c.config.Lifetime - c.config.Expire) > ttl {
 				c.expvarAdd("stale", 1)
 				go c.fetch(key, fetcher)
 			}
 			c.expvarAdd("hits", 1)
-			return err
+			return nil
 		}
 		switch attempts {
 		case 1:
@@ -93,10 +140,57 @@ func (c *DefaultCache) Get(key interface{}, obj interface{}, fetcher Fetcher) er
 	}
 }
 
+// Close releases resources of the cache, e.g. subscription to driver events.
+// Manager.Replace closes replaced instances, data in the cache store isn't affected
+func (c *DefaultCache) Close() error {
+	c.closeOnce.Do(func() {
+		if c.unsubscribe != nil {
+			c.unsubscribe()
+		}
+	})
+	return nil
+}
+
 // Invalidate specific key
 func (c *DefaultCache) Invalidate(key interface{}) error {
 	c.expvarAdd("invalidate_key", 1)
-	return c.config.Driver.Invalidate(c.name, key)
+	return c.driver.Invalidate(c.name, key)
+}
+
+// Config returns configuration of the cache
+func (c *DefaultCache) Config() Config {
+	config := c.config
+	config.Tags = c.Tags()
+	return config
+}
+
+// Stats returns statistics of the cache, counters are the same as in Config.Expvar
+// but they are collected for this cache only
+func (c *DefaultCache) Stats() map[string]int64 {
+	stats := make(map[string]int64)
+	c.stats.Do(func(kv expvar.KeyValue) {
+		if v, ok := kv.Value.(*expvar.Int); ok {
+			stats[kv.Key] = v.Value()
+		}
+	})
+	return stats
+}
+
+// Refresh loads key from Config.Fetcher and saves it to the cache store
+func (c *DefaultCache) Refresh(key interface{}) error {
+	if c.config.Fetcher == nil {
+		return ErrNilFetcher
+	}
+	c.fetchLock.Lock()
+	defer c.fetchLock.Unlock()
+	return c.load(key, c.config.Fetcher)
+}
+
+// Tags returns tags of the cache
+func (c *DefaultCache) Tags() []string {
+	tags := make([]string, len(c.config.Tags))
+	copy(tags, c.config.Tags)
+	return tags
 }
 
 // InvalidateTags invalidates cache if finds necessary tags
@@ -105,7 +199,7 @@ func (c *DefaultCache) InvalidateTags(tags ...string) {
 	for _, t := range tags {
 		for _, ct := range c.config.Tags {
 			if ct == t {
-				c.config.Driver.InvalidateAll(c.name)
+				c.driver.InvalidateAll(c.name)
 				return
 			}
 		}
@@ -115,10 +209,43 @@ func (c *DefaultCache) InvalidateTags(tags ...string) {
 // InvalidateAll invalidates all data from this cache
 func (c *DefaultCache) InvalidateAll() {
 	c.expvarAdd("invalidate_all", 1)
-	c.config.Driver.InvalidateAll(c.name)
+	c.driver.InvalidateAll(c.name)
+}
+
+// get reads the entry of the key, stored is set instead of val for ObjectDriver
+func (c *DefaultCache) get(key interface{}) (val []byte, stored interface{}, ttl time.Duration, err error) {
+	if c.objects != nil {
+		stored, ttl, err = c.objects.GetObject(c.name, key)
+	} else {
+		val, ttl, err = c.driver.Get(c.name, key)
+	}
+	return val, stored, ttl, err
+}
+
+// refetch replaces undecodable entry, the entry is read again under fetchLock,
+// so concurrent readers of the entry fetch it once.
+// Unlike a miss, refetch doesn't skip fetch when another fetch, which could be of another key, is running
+func (c *DefaultCache) refetch(key interface{}, obj interface{}, fetcher Fetcher) error {
+	c.fetchLock.Lock()
+	defer c.fetchLock.Unlock()
+	if val, stored, _, err := c.get(key); err == nil && c.decode(key, val, stored, obj) == nil {
+		return nil
+	}
+	c.expvarAdd("decode_refetches", 1)
+	// Bad entry stays until it's overwritten, so readers don't take it for a miss meanwhile
+	if err := c.load(key, fetcher); err != nil {
+		_ = c.driver.Invalidate(c.name, key)
+		return err
+	}
+	return nil
+}
+
+func (c *DefaultCache) refetchOnDecodeError() bool {
+	return c.config.DecodeErrorPolicy != DecodeErrorReturn
 }
 
 func (c *DefaultCache) expvarAdd(key string, delta int64) {
+	c.stats.Add(key, delta)
 	if c.config.Expvar != nil {
 		c.config.Expvar.Add(key, delta)
 	}
@@ -130,25 +257,7 @@ func (c *DefaultCache) fetch(key interface{}, fetcher Fetcher) {
 		defer atomic.CompareAndSwapInt32(&c.updating, 1, 0)
 		c.fetchLock.Lock()
 		defer c.fetchLock.Unlock()
-		// Getting from fetcher
-		obj, err := fetcher(key)
-		if err != nil {
-			c.expvarAdd("fetch_get_errors", 1)
-			return
-		}
-		// Writing to Redis
-		val, err := c.serialize(obj)
-		if err != nil {
-			c.expvarAdd("fetch_serialize_errors", 1)
-			return
-		}
-		err = c.config.Driver.Set(c.name, key, val, c.config.Lifetime)
-		c.expvarAdd("sets", 1)
-		if err != nil {
-			c.expvarAdd("fetch_write_to_cache_errors", 1)
-			return
-		}
-		c.expvarAdd("fetches", 1)
+		_ = c.load(key, fetcher)
 	} else {
 		// Waiting for another fetch
 		c.fetchLock.RLock()
@@ -157,14 +266,59 @@ func (c *DefaultCache) fetch(key interface{}, fetcher Fetcher) {
 	}
 }
 
-func (c *DefaultCache) serialize(obj interface{}) ([]byte, error) {
+// load gets object from fetcher and writes it to the cache store, must be called under fetchLock
+func (c *DefaultCache) load(key interface{}, fetcher Fetcher) error {
+	// Getting from fetcher
+	obj, err := fetcher(key)
+	if err != nil {
+		c.expvarAdd("fetch_get_errors", 1)
+		return err
+	}
+	// Writing to the cache store
+	if c.objects != nil {
+		err = c.objects.SetObject(c.name, key, obj, c.config.Lifetime)
+	} else {
+		var val []byte
+		if val, err = c.serialize(key, obj); err != nil {
+			c.expvarAdd("fetch_serialize_errors", 1)
+			return err
+		}
+		err = c.driver.Set(c.name, key, val, c.config.Lifetime)
+	}
+	c.expvarAdd("sets", 1)
+	if err != nil {
+		c.expvarAdd("fetch_write_to_cache_errors", 1)
+		return err
+	}
+	c.expvarAdd("fetches", 1)
+	return nil
+}
+
+func (c *DefaultCache) serialize(key interface{}, obj interface{}) ([]byte, error) {
+	if cs, ok := c.config.Serializer.(ContextSerializer); ok {
+		return cs.SerializeContext(obj, EntryContext(c.name, key))
+	}
 	if c.config.Serializer != nil {
 		return c.config.Serializer.Serialize(obj)
 	}
 	return nil, ErrNilSerializer
 }
 
-func (c *DefaultCache) deserialize(src []byte, obj interface{}) error {
+// decode loads stored object or deserializes value to obj
+func (c *DefaultCache) decode(key interface{}, val []byte, stored interface{}, obj interface{}) error {
+	if c.objects == nil {
+		return c.deserialize(key, val, obj)
+	}
+	if c.config.ObjectMode == ObjectModeCopy {
+		stored = deepCopy(stored)
+	}
+	return assignObject(stored, obj)
+}
+
+func (c *DefaultCache) deserialize(key interface{}, src []byte, obj interface{}) error {
+	if cs, ok := c.config.Serializer.(ContextSerializer); ok {
+		return cs.DeserializeContext(src, obj, EntryContext(c.name, key))
+	}
 	if c.config.Serializer != nil {
 		return c.config.Serializer.Deserialize(src, obj)
 	}
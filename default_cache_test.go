@@ -27,6 +27,7 @@ import (
 
 	"errors"
 	"sync"
+	"sync/atomic"
 
 	"github.com/DLag/cachery/drivers/mock"
 	"github.com/stretchr/testify/assert"
@@ -547,3 +548,195 @@ func TestDefaultCache_Invalidate(t *testing.T) {
 		d2.AssertExpectations(t)
 	})
 }
+
+func TestDefaultCache_Refresh(t *testing.T) {
+	a := assert.New(t)
+	fetcher := CacheFetcher{
+		Values: map[interface{}]interface{}{
+			"a": 1,
+		},
+	}
+	s := new(GobSerializer)
+	d := new(mock.Driver)
+	c := NewDefault("CACHE1", Config{
+		Expire:     time.Second * 1,
+		Lifetime:   time.Second * 3,
+		Driver:     d,
+		Serializer: s,
+		Tags:       []string{"tag1"},
+	})
+	a.Equal(ErrNilFetcher, c.Refresh("a"))
+
+	c.config.Fetcher = fetcher.Fetch
+	valSerialized, _ := s.Serialize(1)
+	d.On("Set", c.Name(), "a", valSerialized, time.Second*3).
+		Return(nil).Once()
+	a.NoError(c.Refresh("a"))
+	a.Equal(ErrTest, c.Refresh("b"))
+	d.AssertExpectations(t)
+
+	a.Equal(map[string]int64{"sets": 1, "fetches": 1, "fetch_get_errors": 1}, c.Stats())
+	config := c.Config()
+	a.Equal(time.Second*3, config.Lifetime)
+	config.Tags[0] = "changed"
+	a.Equal([]string{"tag1"}, c.Tags())
+}
+
+func TestDefaultCache_DecodeErrorPolicy(t *testing.T) {
+	a := assert.New(t)
+	fetcher := func(key interface{}) (interface{}, error) {
+		return "fetched", nil
+	}
+	garbage := []byte("garbage")
+	tt := []struct {
+		policy  DecodeErrorPolicy
+		refetch bool
+	}{
+		{DecodeErrorDefault, true},
+		{DecodeErrorRefetch, true},
+		{DecodeErrorReturn, false},
+	}
+	for _, tc := range tt {
+		var quarantined []byte
+		storage := mapDriver{"CACHE:a": garbage}
+		c := NewDefault("CACHE", Config{
+			Expire:            time.Minute,
+			Lifetime:          time.Minute,
+			Serializer:        new(JSONSerializer),
+			Driver:            storage,
+			DecodeErrorPolicy: tc.policy,
+			Quarantine: func(cacheName string, key interface{}, val []byte, err error) {
+				a.Equal("CACHE", cacheName)
+				a.Equal("a", key)
+				a.Error(err)
+				quarantined = val
+			},
+		})
+		// Refetch must not depend on a concurrent fetch of another key
+		atomic.StoreInt32(&c.updating, 1)
+		var val string
+		err := c.Get("a", &val, fetcher)
+		a.Equal(garbage, quarantined)
+		a.Equal(int64(1), c.Stats()["decode_errors"])
+		if tc.refetch {
+			a.NoError(err)
+			a.Equal("fetched", val)
+			a.Equal(int64(1), c.Stats()["decode_refetches"])
+			a.Equal([]byte(`"fetched"`), storage["CACHE:a"])
+		} else {
+			a.Error(err)
+			a.Equal(garbage, storage["CACHE:a"])
+			// Undecodable entry isn't a hit
+			a.Equal(int64(0), c.Stats()["hits"])
+		}
+	}
+}
+
+// lockedMapDriver is mapDriver which could be used concurrently
+type lockedMapDriver struct {
+	sync.Mutex
+	m mapDriver
+}
+
+func (d *lockedMapDriver) Get(cacheName string, key interface{}) ([]byte, time.Duration, error) {
+	d.Lock()
+	defer d.Unlock()
+	return d.m.Get(cacheName, key)
+}
+
+func (d *lockedMapDriver) Set(cacheName string, key interface{}, val []byte, ttl time.Duration) error {
+	d.Lock()
+	defer d.Unlock()
+	return d.m.Set(cacheName, key, val, ttl)
+}
+
+func (d *lockedMapDriver) Invalidate(cacheName string, key interface{}) error {
+	d.Lock()
+	defer d.Unlock()
+	return d.m.Invalidate(cacheName, key)
+}
+
+func (d *lockedMapDriver) InvalidateAll(cacheName string) {}
+
+func TestDefaultCache_ConcurrentRefetch(t *testing.T) {
+	a := assert.New(t)
+	var fetches int32
+	fetcher := func(key interface{}) (interface{}, error) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(time.Millisecond * 10)
+		return "fetched", nil
+	}
+	c := NewDefault("CACHE", Config{
+		Expire:     time.Minute,
+		Lifetime:   time.Minute,
+		Serializer: new(JSONSerializer),
+		Driver:     &lockedMapDriver{m: mapDriver{"CACHE:a": []byte("garbage")}},
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var val string
+			a.NoError(c.Get("a", &val, fetcher))
+			a.Equal("fetched", val)
+		}()
+	}
+	wg.Wait()
+	a.Equal(int32(1), atomic.LoadInt32(&fetches))
+	a.Equal(int64(1), c.Stats()["decode_refetches"])
+}
+
+type notifierDriver struct {
+	mapDriver
+	handlers map[int]func(e Event)
+	last     int
+}
+
+func (d *notifierDriver) Subscribe(f func(e Event)) (func(), error) {
+	d.last++
+	id := d.last
+	d.handlers[id] = f
+	return func() {
+		delete(d.handlers, id)
+	}, nil
+}
+
+func TestDefaultCache_OnEvent(t *testing.T) {
+	a := assert.New(t)
+	d := &notifierDriver{mapDriver: mapDriver{}, handlers: map[int]func(e Event){}}
+	var events []Event
+	c := NewDefault("CACHE1", Config{Driver: d, OnEvent: func(e Event) {
+		events = append(events, e)
+	}})
+	NewDefault("CACHE2", Config{Driver: d})
+	a.Len(d.handlers, 1)
+	for _, h := range d.handlers {
+		h(Event{Type: EventExpired, CacheName: "CACHE1", Key: "a"})
+		h(Event{Type: EventExpired, CacheName: "CACHE2", Key: "b"})
+	}
+	a.Equal([]Event{{Type: EventExpired, CacheName: "CACHE1", Key: "a"}}, events)
+	a.NoError(c.Close())
+	a.NoError(c.Close())
+	a.Len(d.handlers, 0)
+}
+
+func TestDefaultCache_Replace(t *testing.T) {
+	a := assert.New(t)
+	d := &notifierDriver{mapDriver: mapDriver{}, handlers: map[int]func(e Event){}}
+	var events []string
+	onEvent := func(version string) func(e Event) {
+		return func(e Event) {
+			events = append(events, version)
+		}
+	}
+	m := new(Manager)
+	a.NoError(m.Replace(NewDefault("CACHE1", Config{Driver: d, OnEvent: onEvent("old")})))
+	a.NoError(m.Replace(NewDefault("CACHE1", Config{Driver: d, OnEvent: onEvent("new")})))
+	// Replaced instance is closed, so only the new one receives events
+	a.Len(d.handlers, 1)
+	for _, h := range d.handlers {
+		h(Event{Type: EventSet, CacheName: "CACHE1", Key: "a"})
+	}
+	a.Equal([]string{"new"}, events)
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cachery
+
+import (
+	"encoding"
+
+	"github.com/pkg/errors"
+)
+
+// Tags which DispatchSerializer writes before payload
+const (
+	// DispatchBytes payload is []byte as is
+	DispatchBytes byte = 1
+	// DispatchString payload is string as is
+	DispatchString byte = 2
+	// DispatchBinary payload is result of MarshalBinary
+	DispatchBinary byte = 3
+	// DispatchFallback payload is serialized by fallback serializer
+	DispatchFallback byte = 4
+)
+
+// DispatchSerializer chooses serialization by type of the object:
+// []byte and strings are written as is, encoding.BinaryMarshaler uses MarshalBinary
+// and other types are serialized by fallback serializer.
+// One byte tag before payload tells types apart, entries which don't fit the object return ErrBadEntry.
+type DispatchSerializer struct {
+	fallback Serializer
+}
+
+// NewDispatchSerializer creates an instance of DispatchSerializer
+func NewDispatchSerializer(fallback Serializer) *DispatchSerializer {
+	return &DispatchSerializer{fallback: fallback}
+}
+
+// Serialize serializes object to []byte
+func (s *DispatchSerializer) Serialize(obj interface{}) ([]byte, error) {
+	switch o := obj.(type) {
+	case []byte:
+		return append([]byte{DispatchBytes}, o...), nil
+	case string:
+		return append([]byte{DispatchString}, o...), nil
+	case encoding.BinaryMarshaler:
+		data, err := o.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{DispatchBinary}, data...), nil
+	}
+	data, err := s.fallback.Serialize(obj)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{DispatchFallback}, data...), nil
+}
+
+// Deserialize deserializes []byte to object
+func (s *DispatchSerializer) Deserialize(src []byte, obj interface{}) error {
+	if len(src) == 0 {
+		return errors.Wrap(ErrBadEntry, "payload is empty")
+	}
+	tag, payload := src[0], src[1:]
+	switch tag {
+	case DispatchBytes:
+		switch o := obj.(type) {
+		case *[]byte:
+			*o = append([]byte{}, payload...)
+			return nil
+		case *interface{}:
+			*o = append([]byte{}, payload...)
+			return nil
+		}
+	case DispatchString:
+		switch o := obj.(type) {
+		case *string:
+			*o = string(payload)
+			return nil
+		case *interface{}:
+			*o = string(payload)
+			return nil
+		}
+	case DispatchBinary:
+		if o, ok := obj.(encoding.BinaryUnmarshaler); ok {
+			return o.UnmarshalBinary(payload)
+		}
+	case DispatchFallback:
+		return s.fallback.Deserialize(payload, obj)
+	default:
+		return errors.Wrapf(ErrBadEntry, "unknown tag %d", tag)
+	}
+	return errors.Wrapf(ErrBadEntry, "entry with tag %d cannot be read to %T", tag, obj)
+}
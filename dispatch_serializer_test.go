@@ -0,0 +1,74 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cachery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatchSerializer(t *testing.T) {
+	a := assert.New(t)
+	s := NewDispatchSerializer(new(JSONSerializer))
+
+	buf, err := s.Serialize([]byte("raw"))
+	a.NoError(err)
+	a.Equal([]byte{DispatchBytes, 'r', 'a', 'w'}, buf)
+	var b []byte
+	a.NoError(s.Deserialize(buf, &b))
+	a.Equal([]byte("raw"), b)
+	var i interface{}
+	a.NoError(s.Deserialize(buf, &i))
+	a.Equal([]byte("raw"), i)
+
+	buf, err = s.Serialize("str")
+	a.NoError(err)
+	a.Equal([]byte{DispatchString, 's', 't', 'r'}, buf)
+	var str string
+	a.NoError(s.Deserialize(buf, &str))
+	a.Equal("str", str)
+	a.NoError(s.Deserialize(buf, &i))
+	a.Equal("str", i)
+	a.Equal(ErrBadEntry, errors.Cause(s.Deserialize(buf, &b)))
+
+	now := time.Now().Round(0)
+	buf, err = s.Serialize(now)
+	a.NoError(err)
+	a.Equal(DispatchBinary, buf[0])
+	var tm time.Time
+	a.NoError(s.Deserialize(buf, &tm))
+	a.True(now.Equal(tm))
+	a.Equal(ErrBadEntry, errors.Cause(s.Deserialize(buf, &str)))
+
+	buf, err = s.Serialize(map[string]int{"a": 1})
+	a.NoError(err)
+	a.Equal(`{"a":1}`, string(buf[1:]))
+	var m map[string]int
+	a.NoError(s.Deserialize(buf, &m))
+	a.Equal(map[string]int{"a": 1}, m)
+
+	a.Equal(ErrBadEntry, errors.Cause(s.Deserialize(nil, &m)))
+	a.Equal(ErrBadEntry, errors.Cause(s.Deserialize([]byte{42}, &m)))
+}
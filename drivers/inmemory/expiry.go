@@ -0,0 +1,65 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package inmemory
+
+// expiry is min-heap of items by deadline, it satisfies heap.Interface
+type expiry []*item
+
+func (e expiry) Len() int {
+	return len(e)
+}
+
+func (e expiry) Less(i, j int) bool {
+	return e[i].deadline.Before(e[j].deadline)
+}
+
+func (e expiry) Swap(i, j int) {
+	e[i], e[j] = e[j], e[i]
+	e[i].index = i
+	e[j].index = j
+}
+
+// Push adds item to the end of the heap
+func (e *expiry) Push(x interface{}) {
+	i := x.(*item)
+	i.index = len(*e)
+	*e = append(*e, i)
+}
+
+// Pop removes item from the end of the heap
+func (e *expiry) Pop() interface{} {
+	old := *e
+	n := len(old)
+	i := old[n-1]
+	old[n-1] = nil
+	i.index = -1
+	*e = old[:n-1]
+	return i
+}
+
+// peek returns item with the nearest deadline or nil if the heap is empty
+func (e expiry) peek() *item {
+	if len(e) == 0 {
+		return nil
+	}
+	return e[0]
+}
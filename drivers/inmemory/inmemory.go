@@ -22,40 +22,150 @@
 package inmemory
 
 import (
-	"time"
-
+	"container/list"
+	"runtime"
 	"sync"
+	"time"
 
+	"github.com/DLag/cachery"
 	"github.com/pkg/errors"
 )
 
 // ErrNotFound item not found in the cache store
 var ErrNotFound = errors.New("Item not found")
 
+// ErrTooLarge value is bigger than the current limit of bytes of the shard (MaxBytes/Shards), it isn't saved
+var ErrTooLarge = errors.New("Value is too large")
+
 // DefaultTimeout default timeout for cache GC
 var DefaultTimeout = time.Minute
 
-// Driver type satisfies cachery.Driver interface
-type Driver struct {
-	storage     map[string]map[interface{}]*item
-	storageLock sync.RWMutex
+// Scope of capacity limits
+type Scope int
+
+const (
+	// Global limits are shared by all caches of the driver
+	Global Scope = iota
+	// PerCache limits are applied to every cache separately
+	PerCache
+)
+
+// EvictFunc is called for items evicted because of capacity limits, value is nil for objects
+type EvictFunc func(cacheName, key string, value []byte)
+
+// Options of Driver
+type Options struct {
+	// GCInterval interval of removing outdated items, DefaultTimeout if zero.
+	// Items are kept in deadline order, so GC touches only outdated ones.
+	GCInterval time.Duration
+	// ZeroCopy makes Get return stored value without copying,
+	// callers must not modify it (Serializer.Deserialize doesn't)
+	ZeroCopy bool
+	// MaxEntries maximum number of items, the least recently used items are evicted above it,
+	// zero means no limit
+	MaxEntries int
+	// MaxBytes maximum size of values in bytes, the least recently used items are evicted above it,
+	// zero means no limit. Every shard gets MaxBytes/Shards, Set returns ErrTooLarge for values
+	// which are bigger than the share even if other shards are empty
+	MaxBytes int64
+	// HeapLimit size of Go heap in bytes, it's checked every GCInterval
+	// and MaxBytes is shrunk proportionally while heap is bigger, zero disables the check.
+	// If MaxBytes isn't set, items are evicted proportionally to current size and number of items of the caches,
+	// otherwise objects of SetObject are limited by MaxEntries only.
+	HeapLimit uint64
+	// Scope of MaxEntries and MaxBytes
+	Scope Scope
+	// Policy of eviction, LRU by default
+	Policy Policy
+	// OnEvict optional function which is called for evicted items
+	OnEvict EvictFunc
+	// Shards number of independently locked parts of the storage, keys are distributed by hash,
+	// MaxEntries and MaxBytes are divided between shards, so they should be much bigger than Shards
+	// and the biggest value respectively, 1 if zero
+	Shards int
+	// SnapshotPath optional file which is loaded on start, outdated items are discarded
+	SnapshotPath string
+	// SnapshotInterval interval of saving snapshots to SnapshotPath, zero disables periodic saving
+	SnapshotInterval time.Duration
+	// OnSnapshotError optional function which receives errors of loading and periodic saving of snapshots
+	OnSnapshotError func(err error)
 }
 
-type item struct {
-	value    []byte
-	deadline time.Time
+// Stats of Driver
+type Stats struct {
+	// Entries number of items in all caches
+	Entries int
+	// Bytes size of values in all caches
+	Bytes int64
+	// Budget current limit of Bytes in the scope, it's smaller than MaxBytes under heap pressure
+	Budget int64
+	// Evictions number of items evicted because of capacity limits
+	Evictions int64
+	// Caches usage of every cache
+	Caches map[string]CacheStats
 }
 
-type path struct {
+// CacheStats usage of single cache
+type CacheStats struct {
+	// Entries number of items in the cache
+	Entries int
+	// Bytes size of values in the cache
+	Bytes int64
+}
+
+// Driver type satisfies cachery.Driver, cachery.ObjectDriver and cachery.Notifier interfaces
+type Driver struct {
+	shards       []*shard
+	options      Options
+	handlers     map[int]func(e cachery.Event)
+	lastHandler  int
+	handlersLock sync.RWMutex
+}
+
+type item struct {
+	value []byte
+	// object is stored instead of value by SetObject
+	object    interface{}
+	deadline  time.Time
 	cacheName string
-	key       interface{}
+	key       string
+	hash      uint64
+	element   *list.Element
+	segment   int
+	// index in expiry heap, -1 if item isn't there
+	index int
 }
 
 // New creates an instance of Driver type
 func New(gctimeout time.Duration) *Driver {
+	return NewWithOptions(Options{GCInterval: gctimeout})
+}
+
+// NewWithOptions creates an instance of Driver type with options
+func NewWithOptions(options Options) *Driver {
+	if options.GCInterval == 0 {
+		options.GCInterval = DefaultTimeout
+	}
+	if options.Shards <= 0 {
+		options.Shards = 1
+	}
 	driver := new(Driver)
-	driver.storage = make(map[string]map[interface{}]*item)
-	driver.gc(gctimeout)
+	driver.options = options
+	driver.shards = make([]*shard, options.Shards)
+	for i := range driver.shards {
+		driver.shards[i] = newShard(&driver.options, func(items []*item) {
+			driver.emit(cachery.EventExpired, items)
+		})
+	}
+	if options.SnapshotPath != "" {
+		if _, err := driver.LoadSnapshot(options.SnapshotPath); err != nil && options.OnSnapshotError != nil {
+			options.OnSnapshotError(err)
+		}
+		if options.SnapshotInterval > 0 {
+			driver.snapshot(options.SnapshotInterval)
+		}
+	}
+	driver.gc(options.GCInterval)
 	return driver
 }
 
@@ -66,88 +176,203 @@ func Default() *Driver {
 
 // Invalidate removes the key from the cache store
 func (c *Driver) Invalidate(cacheName string, key interface{}) error {
-	c.storageLock.Lock()
-	if _, ok := c.storage[cacheName]; ok {
-		delete(c.storage[cacheName], key)
+	skey := cachery.Key(key)
+	if c.shard(hash(cacheName, skey)).invalidate(cacheName, skey) {
+		c.emitKey(cachery.EventInvalidated, cacheName, skey)
 	}
-	c.storageLock.Unlock()
 	return nil
 }
 
 // InvalidateAll removes all keys from the cache store
 func (c *Driver) InvalidateAll(cacheName string) {
-	c.storageLock.Lock()
-	delete(c.storage, cacheName)
-	c.storageLock.Unlock()
+	for _, s := range c.shards {
+		s.invalidateAll(cacheName)
+	}
+	c.emitKey(cachery.EventInvalidatedAll, cacheName, "")
 }
 
 // Set saves key to the cache store
 func (c *Driver) Set(cacheName string, key interface{}, val []byte, ttl time.Duration) (err error) {
-	c.storageLock.Lock()
-	if _, ok := c.storage[cacheName]; !ok {
-		c.storage[cacheName] = make(map[interface{}]*item)
-	}
-	i := new(item)
+	i := newItem(cacheName, key, ttl)
 	i.value = make([]byte, len(val))
 	copy(i.value, val)
-	i.deadline = time.Now().Add(ttl)
-	c.storage[cacheName][key] = i
-	c.storageLock.Unlock()
-	return nil
+	return c.set(i)
 }
 
 // Get loads key from the cache store if it is not outdated
 func (c *Driver) Get(cacheName string, key interface{}) (val []byte, ttl time.Duration, err error) {
-	c.storageLock.RLock()
-	if _, ok := c.storage[cacheName]; ok {
-		if i, ok := c.storage[cacheName][key]; ok {
-			if ttl = time.Until(i.deadline); ttl > 0 {
-				val = make([]byte, len(i.value))
-				copy(val, i.value)
-				c.storageLock.RUnlock()
-				return
-			} else {
-				c.storageLock.RUnlock()
-				c.sweep([]path{{cacheName, key}})
-				return nil, 0, ErrNotFound
-			}
-		}
+	skey := cachery.Key(key)
+	i, ttl, err := c.shard(hash(cacheName, skey)).get(cacheName, skey)
+	if err != nil {
+		return nil, 0, err
+	}
+	if i.object != nil {
+		return nil, 0, cachery.ErrBadEntry
 	}
-	c.storageLock.RUnlock()
-	return nil, 0, ErrNotFound
+	if c.options.ZeroCopy {
+		return i.value, ttl, nil
+	}
+	val = make([]byte, len(i.value))
+	copy(val, i.value)
+	return val, ttl, nil
 }
 
-func (c *Driver) gc(timeout time.Duration) {
-	c.sweep(c.mark())
-	time.AfterFunc(timeout, func() {
-		c.gc(timeout)
-	})
+// SetObject saves the object itself to the cache store, it isn't copied,
+// so it must not be modified after saving. Objects aren't counted in MaxBytes.
+func (c *Driver) SetObject(cacheName string, key interface{}, obj interface{}, ttl time.Duration) error {
+	if obj == nil {
+		return errors.New("inmemory: object is nil")
+	}
+	i := newItem(cacheName, key, ttl)
+	i.object = obj
+	return c.set(i)
+}
+
+// GetObject loads object saved by SetObject if it is not outdated, the object is shared by all callers
+func (c *Driver) GetObject(cacheName string, key interface{}) (obj interface{}, ttl time.Duration, err error) {
+	skey := cachery.Key(key)
+	i, ttl, err := c.shard(hash(cacheName, skey)).get(cacheName, skey)
+	if err != nil {
+		return nil, 0, err
+	}
+	if i.object == nil {
+		return nil, 0, cachery.ErrBadEntry
+	}
+	return i.object, ttl, nil
+}
+
+// Stats returns statistics of the driver
+func (c *Driver) Stats() Stats {
+	stats := Stats{Caches: make(map[string]CacheStats)}
+	for _, s := range c.shards {
+		s.stats(&stats)
+	}
+	return stats
+}
+
+func newItem(cacheName string, key interface{}, ttl time.Duration) *item {
+	skey := cachery.Key(key)
+	return &item{
+		deadline:  time.Now().Add(ttl),
+		cacheName: cacheName,
+		key:       skey,
+		hash:      hash(cacheName, skey),
+	}
 }
 
-func (c *Driver) sweep(p []path) {
-	c.storageLock.Lock()
-	for pi := range p {
-		if _, ok := c.storage[p[pi].cacheName]; ok {
-			if i, ok := c.storage[p[pi].cacheName][p[pi].key]; ok {
-				if ttl := time.Until(i.deadline); ttl <= 0 {
-					delete(c.storage[p[pi].cacheName], p[pi].key)
-				}
-			}
+// shard returns shard of the key by its hash
+func (c *Driver) shard(h uint64) *shard {
+	return c.shards[h%uint64(len(c.shards))]
+}
+
+// Subscribe registers function which is called on events of all caches, it must not block.
+// Expiration events are emitted when outdated items are removed by GC or on access.
+func (c *Driver) Subscribe(f func(e cachery.Event)) (unsubscribe func(), err error) {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	if c.handlers == nil {
+		c.handlers = make(map[int]func(e cachery.Event))
+	}
+	c.lastHandler++
+	id := c.lastHandler
+	c.handlers[id] = f
+	return func() {
+		c.handlersLock.Lock()
+		delete(c.handlers, id)
+		c.handlersLock.Unlock()
+	}, nil
+}
+
+// set saves item and notifies about it and evicted items
+func (c *Driver) set(i *item) error {
+	evicted, err := c.shard(i.hash).set(i)
+	if err != nil {
+		return err
+	}
+	c.emitKey(cachery.EventSet, i.cacheName, i.key)
+	c.notify(evicted)
+	return nil
+}
+
+// notify calls OnEvict and emits events for evicted items, it must be called without locks
+func (c *Driver) notify(evicted []*item) {
+	if c.options.OnEvict != nil {
+		for _, e := range evicted {
+			c.options.OnEvict(e.cacheName, e.key, e.value)
 		}
 	}
-	c.storageLock.Unlock()
+	c.emit(cachery.EventEvicted, evicted)
 }
 
-func (c *Driver) mark() (marked []path) {
-	c.storageLock.RLock()
-	for k := range c.storage {
-		for v := range c.storage[k] {
-			i := c.storage[k][v]
-			if time.Until(i.deadline) <= 0 {
-				marked = append(marked, path{k, v})
-			}
+// emit calls subscribers for every item, it must be called without locks
+func (c *Driver) emit(t cachery.EventType, items []*item) {
+	if len(items) == 0 {
+		return
+	}
+	c.handlersLock.RLock()
+	defer c.handlersLock.RUnlock()
+	for _, i := range items {
+		for _, h := range c.handlers {
+			h(cachery.Event{Type: t, CacheName: i.cacheName, Key: i.key})
 		}
 	}
-	c.storageLock.RUnlock()
-	return
+}
+
+func (c *Driver) emitKey(t cachery.EventType, cacheName, key string) {
+	c.handlersLock.RLock()
+	defer c.handlersLock.RUnlock()
+	for _, h := range c.handlers {
+		h(cachery.Event{Type: t, CacheName: cacheName, Key: key})
+	}
+}
+
+// checkHeap shrinks caches if Go heap is bigger than HeapLimit
+func (c *Driver) checkHeap() {
+	if c.options.HeapLimit == 0 {
+		return
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	pressure := 1.0
+	if ms.HeapAlloc > c.options.HeapLimit {
+		pressure = float64(c.options.HeapLimit) / float64(ms.HeapAlloc)
+	}
+	c.shrink(pressure)
+}
+
+// shrink sets pressure factor and evicts items which don't fit the budget
+func (c *Driver) shrink(pressure float64) {
+	for _, s := range c.shards {
+		c.notify(s.shrink(pressure))
+	}
+}
+
+// hash returns FNV-1a hash of the item for sharding and frequency sketch
+func hash(cacheName, key string) uint64 {
+	const (
+		offset = 14695981039346656037
+		prime  = 1099511628211
+	)
+	h := uint64(offset)
+	for i := 0; i < len(cacheName); i++ {
+		h = (h ^ uint64(cacheName[i])) * prime
+	}
+	// Zero byte separates cache name and key
+	h *= prime
+	for i := 0; i < len(key); i++ {
+		h = (h ^ uint64(key[i])) * prime
+	}
+	return h
+}
+
+// gc removes outdated items shard by shard, so only one shard is locked at once
+func (c *Driver) gc(timeout time.Duration) {
+	now := time.Now()
+	for _, s := range c.shards {
+		s.expire(now)
+	}
+	c.checkHeap()
+	time.AfterFunc(timeout, func() {
+		c.gc(timeout)
+	})
 }
@@ -22,9 +22,14 @@
 package inmemory
 
 import (
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/DLag/cachery"
 	"github.com/DLag/cachery/tests"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDriver_Cache1SetAndGet(t *testing.T) {
@@ -41,3 +46,484 @@ func TestDriver_Invalidate(t *testing.T) {
 	d := Default()
 	tests.TestInvalidate(t, d, d)
 }
+
+func TestDriver_Keys(t *testing.T) {
+	d := Default()
+	tests.TestKeys(t, d)
+}
+
+func TestDriver_Scan(t *testing.T) {
+	a := assert.New(t)
+	tests.TestScan(t, Default())
+	tests.TestScan(t, NewWithOptions(Options{Shards: 8}))
+
+	// Pages are ordered by hashes and don't overlap
+	d := NewWithOptions(Options{Shards: 4})
+	for i := 0; i < 100; i++ {
+		a.NoError(d.Set("CACHE1", i, []byte("value"), time.Minute))
+	}
+	a.NoError(d.Set("CACHE1", "expired", []byte("value"), -time.Second))
+	var all []string
+	cursor := uint64(0)
+	for {
+		keys, next, err := d.Scan("CACHE1", cursor, 7)
+		a.NoError(err)
+		if next != 0 {
+			a.Len(keys, 7)
+		}
+		for _, k := range keys {
+			h := hash("CACHE1", k)
+			a.True(h >= cursor)
+			if next != 0 {
+				a.True(h < next)
+			}
+		}
+		all = append(all, keys...)
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	a.Len(all, 100)
+	keys, _, err := d.Scan("CACHE1", 0, 0)
+	a.NoError(err)
+	a.Len(keys, DefaultScanCount)
+}
+
+func TestDriver_ZeroCopy(t *testing.T) {
+	d := NewWithOptions(Options{ZeroCopy: true})
+	tests.TestCache1SetAndGet(t, d)
+	tests.TestKeys(t, d)
+}
+
+func TestDriver_Objects(t *testing.T) {
+	a := assert.New(t)
+	var evicted []string
+	d := NewWithOptions(Options{MaxEntries: 1, OnEvict: func(cacheName, key string, value []byte) {
+		a.Nil(value)
+		evicted = append(evicted, key)
+	}})
+	obj := &struct{ Name string }{"name"}
+	a.NoError(d.SetObject("CACHE1", "key", obj, time.Minute))
+	stored, ttl, err := d.GetObject("CACHE1", "key")
+	a.NoError(err)
+	a.True(stored == obj)
+	a.True(ttl > 0)
+	_, _, err = d.Get("CACHE1", "key")
+	a.Equal(cachery.ErrBadEntry, err)
+
+	a.NoError(d.Set("CACHE1", "bytes", []byte("value"), time.Minute))
+	a.Equal([]string{"key"}, evicted)
+	_, _, err = d.GetObject("CACHE1", "bytes")
+	a.Equal(cachery.ErrBadEntry, err)
+	_, _, err = d.GetObject("CACHE1", "key")
+	a.Equal(ErrNotFound, err)
+	a.Error(d.SetObject("CACHE1", "key", nil, time.Minute))
+}
+
+func TestDriver_Events(t *testing.T) {
+	a := assert.New(t)
+	var (
+		events []cachery.Event
+		lock   sync.Mutex
+	)
+	d := NewWithOptions(Options{MaxEntries: 2, GCInterval: time.Hour})
+	unsubscribe, err := d.Subscribe(func(e cachery.Event) {
+		lock.Lock()
+		events = append(events, e)
+		lock.Unlock()
+	})
+	a.NoError(err)
+	a.NoError(d.Set("CACHE1", 1, []byte("value"), time.Minute))
+	a.NoError(d.Set("CACHE1", 2, []byte("value"), time.Millisecond))
+	a.NoError(d.Invalidate("CACHE1", 1))
+	a.NoError(d.Invalidate("CACHE1", 1))
+	time.Sleep(5 * time.Millisecond)
+	d.gc(time.Hour)
+	a.NoError(d.Set("CACHE1", 3, []byte("value"), time.Minute))
+	a.NoError(d.Set("CACHE1", 4, []byte("value"), time.Minute))
+	a.NoError(d.Set("CACHE1", 5, []byte("value"), time.Minute))
+	d.InvalidateAll("CACHE1")
+	unsubscribe()
+	a.NoError(d.Set("CACHE1", 6, []byte("value"), time.Minute))
+
+	lock.Lock()
+	defer lock.Unlock()
+	a.Equal([]cachery.Event{
+		{Type: cachery.EventSet, CacheName: "CACHE1", Key: "1"},
+		{Type: cachery.EventSet, CacheName: "CACHE1", Key: "2"},
+		{Type: cachery.EventInvalidated, CacheName: "CACHE1", Key: "1"},
+		{Type: cachery.EventExpired, CacheName: "CACHE1", Key: "2"},
+		{Type: cachery.EventSet, CacheName: "CACHE1", Key: "3"},
+		{Type: cachery.EventSet, CacheName: "CACHE1", Key: "4"},
+		{Type: cachery.EventSet, CacheName: "CACHE1", Key: "5"},
+		{Type: cachery.EventEvicted, CacheName: "CACHE1", Key: "3"},
+		{Type: cachery.EventInvalidatedAll, CacheName: "CACHE1"},
+	}, events)
+	a.Equal("expired", cachery.EventExpired.String())
+}
+
+func TestDriver_LRU(t *testing.T) {
+	a := assert.New(t)
+	var evicted []string
+	d := NewWithOptions(Options{
+		MaxEntries: 3,
+		OnEvict: func(cacheName, key string, value []byte) {
+			evicted = append(evicted, cacheName+":"+key+"="+string(value))
+		},
+	})
+	tests.TestCache1SetAndGet(t, d)
+	d.InvalidateAll("CACHE1")
+	d.InvalidateAll("CACHE2")
+	evicted = nil
+
+	for _, k := range []string{"a", "b", "c"} {
+		a.NoError(d.Set("CACHE1", k, []byte(k), time.Minute))
+	}
+	// "a" becomes the most recently used
+	_, _, err := d.Get("CACHE1", "a")
+	a.NoError(err)
+	a.NoError(d.Set("CACHE2", "d", []byte("d"), time.Minute))
+	a.Equal([]string{"CACHE1:b=b"}, evicted)
+	_, _, err = d.Get("CACHE1", "b")
+	a.Equal(ErrNotFound, err)
+
+	// Replacing and invalidation don't evict
+	a.NoError(d.Set("CACHE1", "a", []byte("a2"), time.Minute))
+	a.NoError(d.Invalidate("CACHE1", "c"))
+	a.NoError(d.Set("CACHE1", "e", []byte("e"), time.Minute))
+	a.Len(evicted, 1)
+	stats := d.Stats()
+	a.Equal(3, stats.Entries)
+	a.Equal(int64(1), stats.Evictions)
+
+	a.NoError(d.Set("CACHE1", "f", []byte("f"), time.Minute))
+	a.Equal([]string{"CACHE1:b=b", "CACHE2:d=d"}, evicted)
+	d.InvalidateAll("CACHE1")
+	stats = d.Stats()
+	a.Equal(0, stats.Entries)
+	a.Equal(int64(2), stats.Evictions)
+}
+
+func TestDriver_BufferedHits(t *testing.T) {
+	a := assert.New(t)
+	d := NewWithOptions(Options{MaxEntries: 3, Scope: PerCache})
+	for _, k := range []string{"a", "b", "c"} {
+		a.NoError(d.Set("CACHE1", k, []byte(k), time.Minute))
+	}
+	// Hits overflow the buffer
+	for i := 0; i < hitsBuffer*2+1; i++ {
+		_, _, err := d.Get("CACHE1", []string{"a", "b"}[i%2])
+		a.NoError(err)
+	}
+	// Hits of removed and replaced items are skipped
+	a.NoError(d.Set("CACHE1", "b", []byte("b2"), time.Minute))
+	_, _, err := d.Get("CACHE1", "c")
+	a.NoError(err)
+	a.NoError(d.Invalidate("CACHE1", "c"))
+	a.NoError(d.Set("CACHE1", "c", []byte("c2"), time.Minute))
+	a.NoError(d.Set("CACHE1", "d", []byte("d"), time.Minute))
+	_, _, err = d.Get("CACHE1", "a")
+	a.Equal(ErrNotFound, err)
+	_, _, err = d.Get("CACHE1", "d")
+	a.NoError(err)
+	d.InvalidateAll("CACHE1")
+	a.NoError(d.Set("CACHE1", "e", []byte("e"), time.Minute))
+	a.Equal(1, d.Stats().Entries)
+}
+
+func TestDriver_LRUPerCache(t *testing.T) {
+	a := assert.New(t)
+	d := NewWithOptions(Options{MaxEntries: 2, Scope: PerCache})
+	for i := 0; i < 5; i++ {
+		a.NoError(d.Set("CACHE1", i, []byte{byte(i)}, time.Minute))
+		a.NoError(d.Set("CACHE2", i, []byte{byte(i)}, time.Minute))
+	}
+	stats := d.Stats()
+	a.Equal(4, stats.Entries)
+	a.Equal(int64(6), stats.Evictions)
+	for _, cacheName := range []string{"CACHE1", "CACHE2"} {
+		for i := 0; i < 5; i++ {
+			_, _, err := d.Get(cacheName, strconv.Itoa(i))
+			if i < 3 {
+				a.Equal(ErrNotFound, err)
+			} else {
+				a.NoError(err)
+			}
+		}
+	}
+	d.InvalidateAll("CACHE1")
+	a.NoError(d.Set("CACHE2", 5, []byte{5}, time.Minute))
+	stats = d.Stats()
+	a.Equal(2, stats.Entries)
+	a.Equal(int64(7), stats.Evictions)
+
+	// Expired items are removed on Get
+	a.NoError(d.Set("CACHE1", "expired", nil, -time.Second))
+	_, _, err := d.Get("CACHE1", "expired")
+	a.Equal(ErrNotFound, err)
+	a.Equal(2, d.Stats().Entries)
+}
+
+func TestDriver_MaxBytes(t *testing.T) {
+	a := assert.New(t)
+	var evicted []string
+	d := NewWithOptions(Options{
+		MaxBytes: 100,
+		OnEvict: func(cacheName, key string, value []byte) {
+			evicted = append(evicted, cacheName+":"+key)
+		},
+	})
+	a.NoError(d.Set("CACHE1", "a", make([]byte, 40), time.Minute))
+	a.NoError(d.Set("CACHE2", "b", make([]byte, 40), time.Minute))
+	a.NoError(d.Set("CACHE1", "c", make([]byte, 10), time.Minute))
+	stats := d.Stats()
+	a.Equal(int64(90), stats.Bytes)
+	a.Equal(int64(100), stats.Budget)
+	a.Equal(CacheStats{Entries: 2, Bytes: 50}, stats.Caches["CACHE1"])
+	a.Equal(CacheStats{Entries: 1, Bytes: 40}, stats.Caches["CACHE2"])
+
+	a.NoError(d.Set("CACHE2", "d", make([]byte, 30), time.Minute))
+	a.Equal([]string{"CACHE1:a"}, evicted)
+	a.Equal(int64(80), d.Stats().Bytes)
+
+	// Replacing changes size
+	a.NoError(d.Set("CACHE2", "d", make([]byte, 5), time.Minute))
+	a.Equal(int64(55), d.Stats().Bytes)
+
+	// Value bigger than budget is rejected and doesn't evict other items
+	a.Equal(ErrTooLarge, d.Set("CACHE1", "huge", make([]byte, 101), time.Minute))
+	_, _, err := d.Get("CACHE1", "huge")
+	a.Equal(ErrNotFound, err)
+	a.Equal(int64(55), d.Stats().Bytes)
+	a.Len(evicted, 1)
+
+	// Value of the whole budget evicts everything else but stays itself
+	a.NoError(d.Set("CACHE1", "full", make([]byte, 100), time.Minute))
+	_, _, err = d.Get("CACHE1", "full")
+	a.NoError(err)
+	a.Equal(1, d.Stats().Entries)
+
+	a.NoError(d.Set("CACHE1", "a", make([]byte, 10), time.Minute))
+	d.InvalidateAll("CACHE1")
+	a.Equal(int64(0), d.Stats().Bytes)
+	a.Equal(int64(0), d.Stats().Caches["CACHE1"].Bytes)
+}
+
+func TestDriver_MaxBytesPerCache(t *testing.T) {
+	a := assert.New(t)
+	d := NewWithOptions(Options{MaxBytes: 100, Scope: PerCache})
+	for i := 0; i < 5; i++ {
+		a.NoError(d.Set("CACHE1", i, make([]byte, 30), time.Minute))
+		a.NoError(d.Set("CACHE2", i, make([]byte, 20), time.Minute))
+	}
+	stats := d.Stats()
+	a.Equal(CacheStats{Entries: 3, Bytes: 90}, stats.Caches["CACHE1"])
+	a.Equal(CacheStats{Entries: 5, Bytes: 100}, stats.Caches["CACHE2"])
+	a.Equal(int64(2), stats.Evictions)
+}
+
+func TestDriver_HeapPressure(t *testing.T) {
+	a := assert.New(t)
+	d := NewWithOptions(Options{MaxBytes: 100})
+	for i := 0; i < 10; i++ {
+		a.NoError(d.Set("CACHE1", i, make([]byte, 10), time.Minute))
+	}
+	d.shrink(0.5)
+	a.Equal(int64(50), d.Stats().Bytes)
+	a.Equal(int64(50), d.Stats().Budget)
+	a.NoError(d.Set("CACHE1", "a", make([]byte, 10), time.Minute))
+	a.Equal(int64(50), d.Stats().Bytes)
+	d.shrink(1)
+	a.NoError(d.Set("CACHE1", "b", make([]byte, 10), time.Minute))
+	a.Equal(int64(60), d.Stats().Bytes)
+
+	// Without MaxBytes caches are shrunk proportionally
+	d = NewWithOptions(Options{HeapLimit: 1, Scope: PerCache})
+	for i := 0; i < 10; i++ {
+		a.NoError(d.Set("CACHE1", i, make([]byte, 10), time.Minute))
+		a.NoError(d.Set("CACHE2", i, make([]byte, 20), time.Minute))
+	}
+	d.shrink(0.5)
+	a.Equal(CacheStats{Entries: 5, Bytes: 50}, d.Stats().Caches["CACHE1"])
+	a.Equal(CacheStats{Entries: 5, Bytes: 100}, d.Stats().Caches["CACHE2"])
+	// Heap is always bigger than 1 byte
+	d.checkHeap()
+	a.Equal(int64(0), d.Stats().Bytes)
+
+	// Objects aren't counted in bytes, they are shrunk by number
+	d = NewWithOptions(Options{HeapLimit: 1})
+	for i := 0; i < 10; i++ {
+		a.NoError(d.SetObject("CACHE1", i, i, time.Minute))
+	}
+	d.shrink(0.5)
+	a.Equal(5, d.Stats().Entries)
+	d.checkHeap()
+	a.Equal(1, d.Stats().Entries)
+}
+
+func TestDriver_EvictEmptyPolicy(t *testing.T) {
+	a := assert.New(t)
+	d := NewWithOptions(Options{MaxBytes: 100})
+	a.NoError(d.Set("CACHE1", "a", make([]byte, 10), time.Minute))
+	s := d.shards[0]
+	// Bytes which aren't tracked by the policy
+	s.bytes += 1000
+	a.NotPanics(func() {
+		d.shrink(0.5)
+		a.NoError(d.Set("CACHE1", "b", make([]byte, 10), time.Minute))
+	})
+	// Only the new item is left
+	a.Equal(1, s.policy("CACHE1").len())
+}
+
+func TestDriver_Shards(t *testing.T) {
+	a := assert.New(t)
+	d := NewWithOptions(Options{Shards: 8})
+	tests.TestCache1SetAndGet(t, d)
+	tests.TestInvalidate(t, d, d)
+	tests.TestKeys(t, d)
+
+	d = NewWithOptions(Options{Shards: 8, MaxEntries: 80, MaxBytes: 800})
+	for i := 0; i < 1000; i++ {
+		a.NoError(d.Set("CACHE1", i, make([]byte, 5), time.Minute))
+	}
+	stats := d.Stats()
+	a.True(stats.Entries <= 80)
+	a.True(stats.Entries > 40)
+	a.Equal(int64(stats.Entries*5), stats.Bytes)
+	a.Equal(int64(800), stats.Budget)
+	a.Equal(int64(1000-stats.Entries), stats.Evictions)
+	a.Equal(CacheStats{Entries: stats.Entries, Bytes: stats.Bytes}, stats.Caches["CACHE1"])
+	for _, s := range d.shards {
+		a.True(s.entries <= 10)
+	}
+	d.InvalidateAll("CACHE1")
+	a.Equal(0, d.Stats().Entries)
+}
+
+func TestDriver_ShardBudget(t *testing.T) {
+	a := assert.New(t)
+	d := NewWithOptions(Options{Shards: 4, MaxBytes: 400})
+	// Budget is divided between shards, so value bigger than the share is rejected in empty driver
+	a.Equal(ErrTooLarge, d.Set("CACHE1", "a", make([]byte, 101), time.Minute))
+	a.Equal(0, d.Stats().Entries)
+	for i := 0; i < 4; i++ {
+		a.NoError(d.Set("CACHE1", i, make([]byte, 100), time.Minute))
+	}
+	stats := d.Stats()
+	a.Equal(int64(400), stats.Budget)
+	a.True(stats.Bytes <= 400)
+}
+
+func TestDriver_Expire(t *testing.T) {
+	a := assert.New(t)
+	d := NewWithOptions(Options{GCInterval: time.Hour, Shards: 2})
+	for i := 0; i < 100; i++ {
+		ttl := time.Hour
+		if i%2 == 0 {
+			ttl = time.Millisecond
+		}
+		a.NoError(d.Set("CACHE1", i, []byte("value"), ttl))
+	}
+	// Replaced and invalidated items must leave the heap too
+	a.NoError(d.Set("CACHE1", 1, []byte("value"), time.Millisecond))
+	d.Invalidate("CACHE1", 3)
+	a.NoError(d.Set("CACHE2", "key", []byte("value"), time.Millisecond))
+	d.InvalidateAll("CACHE2")
+	time.Sleep(5 * time.Millisecond)
+
+	now := time.Now()
+	for _, s := range d.shards {
+		a.Equal(s.entries, len(s.expiry))
+		s.expire(now)
+		a.Equal(s.entries, len(s.expiry))
+		for j, i := range s.expiry {
+			a.Equal(j, i.index)
+			a.True(i.deadline.After(now))
+		}
+	}
+	a.Equal(48, d.Stats().Entries)
+	_, _, err := d.Get("CACHE1", 1)
+	a.Equal(ErrNotFound, err)
+	_, _, err = d.Get("CACHE1", 5)
+	a.NoError(err)
+}
+
+func BenchmarkDriver_Expire(b *testing.B) {
+	val := make([]byte, 64)
+	for _, n := range []int{1000, 100000} {
+		b.Run("Entries"+strconv.Itoa(n), func(b *testing.B) {
+			d := NewWithOptions(Options{GCInterval: time.Hour})
+			for i := 0; i < n; i++ {
+				_ = d.Set("CACHE", i, val, time.Hour)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// One entry of many expires between ticks
+				b.StopTimer()
+				_ = d.Set("CACHE", -1, val, -time.Second)
+				b.StartTimer()
+				d.shards[0].expire(time.Now())
+			}
+		})
+	}
+}
+
+// BenchmarkDriver_Parallel should be run with -cpu 1,4,16 to see how shards and policies scale
+func BenchmarkDriver_Parallel(b *testing.B) {
+	val := make([]byte, 64)
+	bb := map[string]Options{
+		"Unlimited": {},
+		"LRU":       {MaxEntries: 1 << 20},
+		"TinyLFU":   {MaxEntries: 1 << 20, Policy: TinyLFU},
+	}
+	for name, options := range bb {
+		for _, shards := range []int{1, 4, 16, 64} {
+			options.Shards = shards
+			d := NewWithOptions(options)
+			b.Run(name+"/Shards"+strconv.Itoa(shards), func(b *testing.B) {
+				for i := 0; i < 1024; i++ {
+					_ = d.Set("CACHE", i, val, time.Hour)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					i := 0
+					for pb.Next() {
+						// One write per ten reads
+						if i%10 == 0 {
+							_ = d.Set("CACHE", i%1024, val, time.Hour)
+						} else {
+							_, _, _ = d.Get("CACHE", i%1024)
+						}
+						i++
+					}
+				})
+			})
+		}
+	}
+}
+
+func BenchmarkDriver_Get(b *testing.B) {
+	bb := map[string]Options{
+		"Copy":     {},
+		"ZeroCopy": {ZeroCopy: true},
+	}
+	val := make([]byte, 4096)
+	for name, options := range bb {
+		b.Run(name, func(b *testing.B) {
+			d := NewWithOptions(options)
+			if err := d.Set("CACHE", "key", val, time.Hour); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := d.Get("CACHE", "key"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
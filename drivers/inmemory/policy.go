@@ -0,0 +1,93 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package inmemory
+
+import "container/list"
+
+// Policy of eviction when capacity of the driver is limited
+type Policy int
+
+const (
+	// LRU evicts the least recently used items
+	LRU Policy = iota
+	// TinyLFU is W-TinyLFU policy: new items get to small LRU window
+	// and they are admitted to the main segmented LRU only if they are used more frequently than its victim,
+	// so one-off scans don't flush frequently used items
+	TinyLFU
+)
+
+// policy orders items of the scope for eviction
+type policy interface {
+	// add adds new item
+	add(i *item)
+	// touch records usage of the item
+	touch(i *item)
+	// remove removes item
+	remove(i *item)
+	// victim returns item which should be evicted or nil if there are no items
+	victim() *item
+	len() int
+}
+
+func newPolicy(p Policy, capacity int) policy {
+	if p == TinyLFU {
+		return newTinyLFU(capacity)
+	}
+	return newLRU()
+}
+
+// lru keeps items in order of usage, the least recently used item is at the back
+type lru struct {
+	list *list.List
+}
+
+func newLRU() *lru {
+	return &lru{list: list.New()}
+}
+
+// add puts new item to the front
+func (l *lru) add(i *item) {
+	i.element = l.list.PushFront(i)
+}
+
+// touch moves item to the front
+func (l *lru) touch(i *item) {
+	l.list.MoveToFront(i.element)
+}
+
+// remove removes item from the list
+func (l *lru) remove(i *item) {
+	l.list.Remove(i.element)
+	i.element = nil
+}
+
+// victim returns the least recently used item or nil if the list is empty
+func (l *lru) victim() *item {
+	if e := l.list.Back(); e != nil {
+		return e.Value.(*item)
+	}
+	return nil
+}
+
+func (l *lru) len() int {
+	return l.list.Len()
+}
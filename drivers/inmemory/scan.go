@@ -0,0 +1,122 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package inmemory
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// DefaultScanCount number of keys returned by Scan if count isn't positive
+var DefaultScanCount = 10
+
+// Scan returns keys of the cache in order of their hashes, cursor is the hash to start from.
+// Every call looks through all items of the cache, so count should be big for big caches.
+func (c *Driver) Scan(cacheName string, cursor uint64, count int) (keys []string, next uint64, err error) {
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+	// Hash of the last key of the page is found first, then all keys up to it are collected,
+	// so keys with the same hash are never split between pages
+	now := time.Now()
+	var bound hashHeap
+	for _, s := range c.shards {
+		s.scanHashes(cacheName, cursor, now, count, &bound)
+	}
+	if len(bound) == 0 {
+		return nil, 0, nil
+	}
+	last := bound[0]
+	if len(bound) == count {
+		// Zero after the maximal hash is the end of iteration too
+		next = last + 1
+	}
+	var page []*item
+	for _, s := range c.shards {
+		page = s.scanItems(cacheName, cursor, last, now, page)
+	}
+	sort.Slice(page, func(i, j int) bool {
+		return page[i].hash < page[j].hash
+	})
+	keys = make([]string, len(page))
+	for i, p := range page {
+		keys[i] = p.key
+	}
+	return keys, next, nil
+}
+
+// scanHashes keeps in h count smallest hashes of cache items which aren't less than cursor
+func (s *shard) scanHashes(cacheName string, cursor uint64, now time.Time, count int, h *hashHeap) {
+	s.storageLock.RLock()
+	defer s.storageLock.RUnlock()
+	for _, i := range s.storage[cacheName] {
+		switch {
+		case i.hash < cursor, !i.deadline.After(now):
+		case len(*h) < count:
+			heap.Push(h, i.hash)
+		case i.hash < (*h)[0]:
+			(*h)[0] = i.hash
+			heap.Fix(h, 0)
+		}
+	}
+}
+
+// scanItems appends items of the cache with hashes from cursor to last
+func (s *shard) scanItems(cacheName string, cursor, last uint64, now time.Time, page []*item) []*item {
+	s.storageLock.RLock()
+	defer s.storageLock.RUnlock()
+	for _, i := range s.storage[cacheName] {
+		if i.hash >= cursor && i.hash <= last && i.deadline.After(now) {
+			page = append(page, i)
+		}
+	}
+	return page
+}
+
+// hashHeap is max-heap of hashes, it satisfies heap.Interface
+type hashHeap []uint64
+
+func (h hashHeap) Len() int {
+	return len(h)
+}
+
+func (h hashHeap) Less(i, j int) bool {
+	return h[i] > h[j]
+}
+
+func (h hashHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+// Push adds hash to the end of the heap
+func (h *hashHeap) Push(x interface{}) {
+	*h = append(*h, x.(uint64))
+}
+
+// Pop removes hash from the end of the heap
+func (h *hashHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
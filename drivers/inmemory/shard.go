@@ -0,0 +1,361 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package inmemory
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// hitsBuffer number of hits which are buffered before they are applied to eviction policies
+const hitsBuffer = 64
+
+// shard is independently locked part of the storage
+type shard struct {
+	storage     map[string]map[string]*item
+	storageLock sync.RWMutex
+	// expiry orders items by deadline, so GC touches only outdated items
+	expiry  expiry
+	options *Options
+	// expired receives items removed because of deadline, it's called without locks
+	expired func(items []*item)
+	// maxEntries and maxBytes are shares of the limits of the driver
+	maxEntries int
+	maxBytes   int64
+	// policies order items for eviction in scopes, they are used only if capacity is limited
+	policies map[string]policy
+	// hits are applied to policies in batches, so hits don't take exclusive storageLock
+	hits       chan *item
+	entries    int
+	bytes      int64
+	cacheBytes map[string]int64
+	evictions  int64
+	// pressure factor of maxBytes, it's less than 1 when heap is bigger than HeapLimit
+	pressure float64
+}
+
+func newShard(options *Options, expired func(items []*item)) *shard {
+	s := &shard{
+		storage:    make(map[string]map[string]*item),
+		options:    options,
+		expired:    expired,
+		policies:   make(map[string]policy),
+		cacheBytes: make(map[string]int64),
+		pressure:   1,
+	}
+	if options.MaxEntries > 0 {
+		if s.maxEntries = options.MaxEntries / options.Shards; s.maxEntries == 0 {
+			s.maxEntries = 1
+		}
+	}
+	if options.MaxBytes > 0 {
+		if s.maxBytes = options.MaxBytes / int64(options.Shards); s.maxBytes == 0 {
+			s.maxBytes = 1
+		}
+	}
+	if s.limited() {
+		s.hits = make(chan *item, hitsBuffer)
+	}
+	return s
+}
+
+// invalidate removes the item and reports whether it existed
+func (s *shard) invalidate(cacheName, key string) bool {
+	s.storageLock.Lock()
+	i, ok := s.storage[cacheName][key]
+	if ok {
+		s.remove(i)
+	}
+	s.storageLock.Unlock()
+	return ok
+}
+
+func (s *shard) invalidateAll(cacheName string) {
+	s.storageLock.Lock()
+	s.entries -= len(s.storage[cacheName])
+	s.removeExpiry(cacheName)
+	s.bytes -= s.cacheBytes[cacheName]
+	delete(s.cacheBytes, cacheName)
+	if s.limited() {
+		if s.options.Scope == PerCache {
+			delete(s.policies, cacheName)
+		} else if p := s.policies[""]; p != nil {
+			for _, i := range s.storage[cacheName] {
+				p.remove(i)
+			}
+		}
+	}
+	delete(s.storage, cacheName)
+	s.storageLock.Unlock()
+}
+
+// set saves item and returns items evicted because of limits, values bigger than the budget are rejected
+func (s *shard) set(i *item) (evicted []*item, err error) {
+	s.storageLock.Lock()
+	defer s.storageLock.Unlock()
+	if budget := s.budget(); budget > 0 && int64(len(i.value)) > budget {
+		return nil, ErrTooLarge
+	}
+	if _, ok := s.storage[i.cacheName]; !ok {
+		s.storage[i.cacheName] = make(map[string]*item)
+	}
+	if old, ok := s.storage[i.cacheName][i.key]; ok {
+		s.remove(old)
+	}
+	if s.limited() {
+		// Earlier hits go before the new item
+		s.applyHits()
+		// Room is made before adding, so the new item is never evicted itself
+		evicted = s.evict(s.scope(i.cacheName), s.maxEntries, s.budget(), i)
+	}
+	s.storage[i.cacheName][i.key] = i
+	heap.Push(&s.expiry, i)
+	s.entries++
+	s.bytes += int64(len(i.value))
+	s.cacheBytes[i.cacheName] += int64(len(i.value))
+	if s.limited() {
+		s.policy(i.cacheName).add(i)
+	}
+	return evicted, nil
+}
+
+// get returns item which isn't outdated, fields of stored items are never changed
+// so they could be read without storageLock
+func (s *shard) get(cacheName, key string) (i *item, ttl time.Duration, err error) {
+	s.storageLock.RLock()
+	i, ok := s.storage[cacheName][key]
+	if !ok {
+		s.storageLock.RUnlock()
+		return nil, 0, ErrNotFound
+	}
+	if ttl = time.Until(i.deadline); ttl <= 0 {
+		s.storageLock.RUnlock()
+		s.expire(time.Now())
+		return nil, 0, ErrNotFound
+	}
+	s.storageLock.RUnlock()
+	if s.limited() {
+		s.hit(i)
+	}
+	return i, ttl, nil
+}
+
+// hit buffers usage of the item, the buffer is applied to policies when it's full or before eviction
+func (s *shard) hit(i *item) {
+	select {
+	case s.hits <- i:
+	default:
+		s.storageLock.Lock()
+		s.applyHits()
+		s.touch(i)
+		s.storageLock.Unlock()
+	}
+}
+
+// applyHits applies buffered hits to policies, must be called under storageLock
+func (s *shard) applyHits() {
+	// Hits which are buffered concurrently wait for the next batch
+	for n := len(s.hits); n > 0; n-- {
+		s.touch(<-s.hits)
+	}
+}
+
+// touch changes order of usage of the item unless it was removed after the hit, must be called under storageLock
+func (s *shard) touch(i *item) {
+	if s.storage[i.cacheName][i.key] == i {
+		s.policy(i.cacheName).touch(i)
+	}
+}
+
+// stats adds statistics of the shard
+func (s *shard) stats(stats *Stats) {
+	s.storageLock.RLock()
+	defer s.storageLock.RUnlock()
+	stats.Entries += s.entries
+	stats.Bytes += s.bytes
+	stats.Budget += s.budget()
+	stats.Evictions += s.evictions
+	for cacheName, items := range s.storage {
+		cs := stats.Caches[cacheName]
+		cs.Entries += len(items)
+		cs.Bytes += s.cacheBytes[cacheName]
+		stats.Caches[cacheName] = cs
+	}
+}
+
+// limited returns true if capacity of the driver is limited
+func (s *shard) limited() bool {
+	return s.maxEntries > 0 || s.maxBytes > 0 || s.options.HeapLimit > 0
+}
+
+// scope returns name of the scope of the cache
+func (s *shard) scope(cacheName string) string {
+	if s.options.Scope == PerCache {
+		return cacheName
+	}
+	return ""
+}
+
+// policy returns eviction policy of the scope of the cache, must be called under storageLock
+func (s *shard) policy(cacheName string) policy {
+	scope := s.scope(cacheName)
+	p, ok := s.policies[scope]
+	if !ok {
+		p = newPolicy(s.options.Policy, s.maxEntries)
+		s.policies[scope] = p
+	}
+	return p
+}
+
+// budget returns current limit of bytes in every scope, must be called under storageLock
+func (s *shard) budget() int64 {
+	return int64(float64(s.maxBytes) * s.pressure)
+}
+
+// scopeBytes returns size of values in the scope, must be called under storageLock
+func (s *shard) scopeBytes(scope string) int64 {
+	if scope == "" {
+		return s.bytes
+	}
+	return s.cacheBytes[scope]
+}
+
+// evict removes items of the scope chosen by policy until it fits limits together with incoming item if it's set,
+// zero limit and budget mean no limit of entries and bytes, must be called under storageLock
+func (s *shard) evict(scope string, limit int, budget int64, incoming *item) (evicted []*item) {
+	p := s.policies[scope]
+	if p == nil {
+		return nil
+	}
+	var entries int
+	var size int64
+	if incoming != nil {
+		entries, size = 1, int64(len(incoming.value))
+	}
+	for {
+		overflow := limit > 0 && p.len()+entries > limit
+		overflow = overflow || budget > 0 && s.scopeBytes(scope)+size > budget
+		if !overflow {
+			break
+		}
+		victim := p.victim()
+		if victim == nil {
+			// Nothing left to evict, the rest of the budget is used by items outside of the policy
+			break
+		}
+		s.remove(victim)
+		evicted = append(evicted, victim)
+	}
+	s.evictions += int64(len(evicted))
+	return evicted
+}
+
+// shrink sets pressure factor and evicts items which don't fit the budget
+func (s *shard) shrink(pressure float64) (evicted []*item) {
+	s.storageLock.Lock()
+	defer s.storageLock.Unlock()
+	s.pressure = pressure
+	s.applyHits()
+	for scope, p := range s.policies {
+		limit, budget := s.maxEntries, s.budget()
+		if s.maxBytes == 0 {
+			if pressure >= 1 {
+				continue
+			}
+			// Without MaxBytes the scope is shrunk proportionally to its size and number of items,
+			// the latter reclaims objects which aren't counted in bytes
+			if budget = int64(float64(s.scopeBytes(scope)) * pressure); budget == 0 {
+				budget = 1
+			}
+			if n := int(float64(p.len()) * pressure); limit == 0 || n < limit {
+				if limit = n; limit == 0 {
+					limit = 1
+				}
+			}
+		}
+		evicted = append(evicted, s.evict(scope, limit, budget, nil)...)
+	}
+	return evicted
+}
+
+// remove deletes item from storage and eviction policy, must be called under storageLock
+func (s *shard) remove(i *item) {
+	delete(s.storage[i.cacheName], i.key)
+	if i.index >= 0 {
+		heap.Remove(&s.expiry, i.index)
+	}
+	s.entries--
+	s.bytes -= int64(len(i.value))
+	s.cacheBytes[i.cacheName] -= int64(len(i.value))
+	if i.element != nil {
+		s.policy(i.cacheName).remove(i)
+	}
+}
+
+// items returns all items of the shard
+func (s *shard) items() []*item {
+	s.storageLock.RLock()
+	defer s.storageLock.RUnlock()
+	items := make([]*item, 0, s.entries)
+	for _, cache := range s.storage {
+		for _, i := range cache {
+			items = append(items, i)
+		}
+	}
+	return items
+}
+
+// expire removes items which are outdated at the moment
+func (s *shard) expire(now time.Time) {
+	var expired []*item
+	s.storageLock.Lock()
+	for i := s.expiry.peek(); i != nil && !i.deadline.After(now); i = s.expiry.peek() {
+		s.remove(i)
+		expired = append(expired, i)
+	}
+	s.storageLock.Unlock()
+	if len(expired) > 0 && s.expired != nil {
+		s.expired(expired)
+	}
+}
+
+// removeExpiry removes items of the cache from expiry heap, must be called under storageLock
+func (s *shard) removeExpiry(cacheName string) {
+	if len(s.storage[cacheName]) == 0 {
+		return
+	}
+	kept := s.expiry[:0]
+	for _, i := range s.expiry {
+		if i.cacheName == cacheName {
+			i.index = -1
+			continue
+		}
+		i.index = len(kept)
+		kept = append(kept, i)
+	}
+	for j := len(kept); j < len(s.expiry); j++ {
+		s.expiry[j] = nil
+	}
+	s.expiry = kept
+	heap.Init(&s.expiry)
+}
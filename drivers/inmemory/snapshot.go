@@ -0,0 +1,227 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package inmemory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SnapshotVersion version of snapshot format written by Driver
+const SnapshotVersion = 1
+
+// ErrBadSnapshot snapshot has unknown format or its checksum doesn't match
+var ErrBadSnapshot = errors.New("inmemory: bad snapshot")
+
+var snapshotMagic = []byte("CSNP")
+
+const (
+	snapshotEnd    = 0
+	snapshotRecord = 1
+)
+
+// Snapshot format:
+//   magic "CSNP", version byte,
+//   records: 1, uvarint length and cache name, uvarint length and key, deadline as 8 bytes of unix nanoseconds,
+//   uvarint length and value,
+//   end marker 0, CRC-32 (IEEE) of all previous bytes.
+// Integers are big-endian.
+
+// WriteSnapshot writes items of all caches to w, objects stored by SetObject are skipped
+func (c *Driver) WriteSnapshot(w io.Writer) error {
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	bw.Write(snapshotMagic)
+	bw.WriteByte(SnapshotVersion)
+	var buf [binary.MaxVarintLen64]byte
+	writeBytes := func(b []byte) {
+		n := binary.PutUvarint(buf[:], uint64(len(b)))
+		bw.Write(buf[:n])
+		bw.Write(b)
+	}
+	now := time.Now()
+	for _, s := range c.shards {
+		for _, i := range s.items() {
+			if i.object != nil || !i.deadline.After(now) {
+				continue
+			}
+			bw.WriteByte(snapshotRecord)
+			writeBytes([]byte(i.cacheName))
+			writeBytes([]byte(i.key))
+			binary.BigEndian.PutUint64(buf[:8], uint64(i.deadline.UnixNano()))
+			bw.Write(buf[:8])
+			writeBytes(i.value)
+		}
+	}
+	bw.WriteByte(snapshotEnd)
+	if err := bw.Flush(); err != nil {
+		return errors.Wrap(err, "inmemory: cannot write snapshot")
+	}
+	binary.BigEndian.PutUint32(buf[:4], crc.Sum32())
+	_, err := w.Write(buf[:4])
+	return errors.Wrap(err, "inmemory: cannot write snapshot")
+}
+
+// ReadSnapshot loads items written by WriteSnapshot and returns their number,
+// outdated items and values which don't fit MaxBytes are discarded.
+// Nothing is loaded if the snapshot is corrupted.
+func (c *Driver) ReadSnapshot(r io.Reader) (int, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, errors.Wrap(err, "inmemory: cannot read snapshot")
+	}
+	if len(data) < len(snapshotMagic)+1+1+4 || !bytes.Equal(data[:len(snapshotMagic)], snapshotMagic) {
+		return 0, ErrBadSnapshot
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(data[len(data)-4:]) {
+		return 0, errors.Wrap(ErrBadSnapshot, "checksum mismatch")
+	}
+	if v := body[len(snapshotMagic)]; v != SnapshotVersion {
+		return 0, errors.Wrapf(ErrBadSnapshot, "unknown version %d", v)
+	}
+	var items []*item
+	if items, err = parseSnapshot(body[len(snapshotMagic)+1:], time.Now()); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, i := range items {
+		if c.set(i) == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// SaveSnapshot writes snapshot to the file atomically, the file is replaced only after successful write
+func (c *Driver) SaveSnapshot(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "inmemory: cannot create snapshot")
+	}
+	err = c.WriteSnapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.Wrap(err, "inmemory: cannot save snapshot")
+	}
+	return nil
+}
+
+// LoadSnapshot loads snapshot from the file and returns number of loaded items, missing file isn't an error
+func (c *Driver) LoadSnapshot(path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "inmemory: cannot load snapshot")
+	}
+	defer f.Close()
+	return c.ReadSnapshot(f)
+}
+
+// snapshot saves snapshot to Options.SnapshotPath periodically
+func (c *Driver) snapshot(interval time.Duration) {
+	time.AfterFunc(interval, func() {
+		if err := c.SaveSnapshot(c.options.SnapshotPath); err != nil && c.options.OnSnapshotError != nil {
+			c.options.OnSnapshotError(err)
+		}
+		c.snapshot(interval)
+	})
+}
+
+func parseSnapshot(data []byte, now time.Time) ([]*item, error) {
+	var items []*item
+	readBytes := func() ([]byte, error) {
+		l, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < l {
+			return nil, errors.Wrap(ErrBadSnapshot, "truncated record")
+		}
+		b := data[n : n+int(l)]
+		data = data[n+int(l):]
+		return b, nil
+	}
+	for {
+		if len(data) == 0 {
+			return nil, errors.Wrap(ErrBadSnapshot, "no end marker")
+		}
+		marker := data[0]
+		data = data[1:]
+		if marker == snapshotEnd {
+			break
+		}
+		if marker != snapshotRecord {
+			return nil, errors.Wrap(ErrBadSnapshot, "unknown record")
+		}
+		cacheName, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		key, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < 8 {
+			return nil, errors.Wrap(ErrBadSnapshot, "truncated record")
+		}
+		deadline := time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+		data = data[8:]
+		value, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		if !deadline.After(now) {
+			continue
+		}
+		i := &item{
+			value:     make([]byte, len(value)),
+			deadline:  deadline,
+			cacheName: string(cacheName),
+			key:       string(key),
+			hash:      hash(string(cacheName), string(key)),
+		}
+		copy(i.value, value)
+		items = append(items, i)
+	}
+	if len(data) != 0 {
+		return nil, errors.Wrap(ErrBadSnapshot, "data after end marker")
+	}
+	return items, nil
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package inmemory
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDriver_Snapshot(t *testing.T) {
+	a := assert.New(t)
+	d := NewWithOptions(Options{Shards: 4})
+	for i := 0; i < 100; i++ {
+		a.NoError(d.Set("CACHE1", i, []byte{byte(i)}, time.Hour))
+	}
+	a.NoError(d.Set("CACHE2", "empty", []byte{}, time.Hour))
+	a.NoError(d.Set("CACHE2", "expiring", []byte("value"), 20*time.Millisecond))
+	a.NoError(d.SetObject("CACHE2", "object", "value", time.Hour))
+
+	var buf bytes.Buffer
+	a.NoError(d.WriteSnapshot(&buf))
+	time.Sleep(30 * time.Millisecond)
+
+	l := NewWithOptions(Options{})
+	n, err := l.ReadSnapshot(bytes.NewReader(buf.Bytes()))
+	a.NoError(err)
+	a.Equal(101, n)
+	for i := 0; i < 100; i++ {
+		val, ttl, err := l.Get("CACHE1", i)
+		a.NoError(err)
+		a.Equal([]byte{byte(i)}, val)
+		a.True(ttl > 59*time.Minute)
+	}
+	val, _, err := l.Get("CACHE2", "empty")
+	a.NoError(err)
+	a.Len(val, 0)
+	_, _, err = l.Get("CACHE2", "expiring")
+	a.Equal(ErrNotFound, err)
+	_, _, err = l.GetObject("CACHE2", "object")
+	a.Equal(ErrNotFound, err)
+
+	// Corrupted snapshots are rejected entirely
+	data := buf.Bytes()
+	for _, bad := range [][]byte{
+		nil,
+		[]byte("CSNP"),
+		append([]byte("XXXX"), data[4:]...),
+		data[:len(data)-1],
+		append(append([]byte{}, data[:10]...), append([]byte{data[10] ^ 1}, data[11:]...)...),
+	} {
+		l = NewWithOptions(Options{})
+		n, err = l.ReadSnapshot(bytes.NewReader(bad))
+		a.Equal(ErrBadSnapshot, errors.Cause(err))
+		a.Equal(0, n)
+		a.Equal(0, l.Stats().Entries)
+	}
+}
+
+func TestDriver_SnapshotFile(t *testing.T) {
+	a := assert.New(t)
+	dir, err := ioutil.TempDir("", "cachery")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot")
+
+	// Missing file is an empty cache
+	d := NewWithOptions(Options{SnapshotPath: path, SnapshotInterval: 10 * time.Millisecond})
+	a.Equal(0, d.Stats().Entries)
+	a.NoError(d.Set("CACHE1", "key", []byte("value"), time.Hour))
+	time.Sleep(50 * time.Millisecond)
+
+	l := NewWithOptions(Options{SnapshotPath: path})
+	val, _, err := l.Get("CACHE1", "key")
+	a.NoError(err)
+	a.Equal([]byte("value"), val)
+
+	a.NoError(d.Set("CACHE1", "key2", []byte("value2"), time.Hour))
+	a.NoError(d.SaveSnapshot(path))
+	n, err := l.LoadSnapshot(path)
+	a.NoError(err)
+	a.Equal(2, n)
+	garbage := filepath.Join(dir, "garbage")
+	a.NoError(ioutil.WriteFile(garbage, []byte("garbage"), 0600))
+	var errs []error
+	NewWithOptions(Options{SnapshotPath: garbage, OnSnapshotError: func(err error) {
+		errs = append(errs, err)
+	}})
+	a.Len(errs, 1)
+	a.Equal(ErrBadSnapshot, errors.Cause(errs[0]))
+}
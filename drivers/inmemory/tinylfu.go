@@ -0,0 +1,219 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package inmemory
+
+import "container/list"
+
+// Segments of W-TinyLFU
+const (
+	segmentWindow = iota
+	segmentProbation
+	segmentProtected
+)
+
+const (
+	// windowPercent share of items in the window
+	windowPercent = 1
+	// protectedPercent share of items of the main segment in the protected segment
+	protectedPercent = 80
+	// defaultSketchWidth width of the sketch if number of items isn't limited
+	defaultSketchWidth = 1 << 16
+)
+
+// tinyLFU implements W-TinyLFU policy.
+// New items get to the window, the window overflow moves to probation segment of the main segmented LRU.
+// On eviction the newest item of probation competes with the oldest one by frequency,
+// items used in probation are promoted to protected segment.
+type tinyLFU struct {
+	segments [3]*list.List
+	sketch   *sketch
+}
+
+func newTinyLFU(capacity int) *tinyLFU {
+	t := &tinyLFU{sketch: newSketch(capacity)}
+	for i := range t.segments {
+		t.segments[i] = list.New()
+	}
+	return t
+}
+
+func (t *tinyLFU) add(i *item) {
+	t.sketch.increment(i.hash)
+	t.push(i, segmentWindow)
+	// Window keeps small share of items
+	window := t.segments[segmentWindow]
+	if window.Len() > 1 && window.Len()*100 > t.len()*windowPercent {
+		t.move(window.Back().Value.(*item), segmentProbation)
+	}
+}
+
+func (t *tinyLFU) touch(i *item) {
+	t.sketch.increment(i.hash)
+	switch i.segment {
+	case segmentProbation:
+		t.move(i, segmentProtected)
+		protected := t.segments[segmentProtected]
+		main := protected.Len() + t.segments[segmentProbation].Len()
+		if protected.Len() > 1 && protected.Len()*100 > main*protectedPercent {
+			t.move(protected.Back().Value.(*item), segmentProbation)
+		}
+	default:
+		t.segments[i.segment].MoveToFront(i.element)
+	}
+}
+
+func (t *tinyLFU) remove(i *item) {
+	t.segments[i.segment].Remove(i.element)
+	i.element = nil
+}
+
+// victim returns less frequently used item of the newest and the oldest items of probation segment
+func (t *tinyLFU) victim() *item {
+	probation := t.segments[segmentProbation]
+	if probation.Len() > 1 {
+		candidate := probation.Front().Value.(*item)
+		victim := probation.Back().Value.(*item)
+		// Candidate is admitted only if it's used more frequently
+		if t.sketch.frequency(candidate.hash) > t.sketch.frequency(victim.hash) {
+			return victim
+		}
+		return candidate
+	}
+	for _, s := range []int{segmentProbation, segmentWindow, segmentProtected} {
+		if e := t.segments[s].Back(); e != nil {
+			return e.Value.(*item)
+		}
+	}
+	return nil
+}
+
+func (t *tinyLFU) len() int {
+	return t.segments[segmentWindow].Len() + t.segments[segmentProbation].Len() + t.segments[segmentProtected].Len()
+}
+
+func (t *tinyLFU) push(i *item, segment int) {
+	i.segment = segment
+	i.element = t.segments[segment].PushFront(i)
+}
+
+func (t *tinyLFU) move(i *item, segment int) {
+	t.segments[i.segment].Remove(i.element)
+	t.push(i, segment)
+}
+
+// sketch is count-min sketch with doorkeeper bloom filter which estimates frequency of keys,
+// counters are halved periodically to forget old history
+type sketch struct {
+	counters   [4][]uint8
+	doorkeeper []uint64
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+func newSketch(capacity int) *sketch {
+	width := defaultSketchWidth
+	if capacity > 0 {
+		width = 16
+		for width < capacity {
+			width <<= 1
+		}
+	}
+	s := &sketch{
+		doorkeeper: make([]uint64, width/64+1),
+		mask:       uint64(width - 1),
+		sampleSize: width * 10,
+	}
+	for i := range s.counters {
+		s.counters[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// maxCount saturation value of counters
+const maxCount = 15
+
+func (s *sketch) increment(h uint64) {
+	// The first occurrence is recorded by doorkeeper only
+	if !s.door(h) {
+		return
+	}
+	for i := range s.counters {
+		if c := &s.counters[i][s.index(h, i)]; *c < maxCount {
+			*c++
+		}
+	}
+	if s.additions++; s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+func (s *sketch) frequency(h uint64) int {
+	min := uint8(maxCount)
+	for i := range s.counters {
+		if c := s.counters[i][s.index(h, i)]; c < min {
+			min = c
+		}
+	}
+	f := int(min)
+	if s.seen(h) {
+		f++
+	}
+	return f
+}
+
+func (s *sketch) index(h uint64, i int) uint64 {
+	return (h + uint64(i)*(h>>32|1)) & s.mask
+}
+
+// door sets bit of doorkeeper and returns true if it was set already
+func (s *sketch) door(h uint64) bool {
+	if s.seen(h) {
+		return true
+	}
+	b := s.doorBit(h)
+	s.doorkeeper[b/64] |= 1 << (b % 64)
+	return false
+}
+
+func (s *sketch) seen(h uint64) bool {
+	b := s.doorBit(h)
+	return s.doorkeeper[b/64]&(1<<(b%64)) != 0
+}
+
+// doorBit uses other bits of hash than counters
+func (s *sketch) doorBit(h uint64) uint64 {
+	return (h >> 24) & s.mask
+}
+
+// reset halves counters and clears doorkeeper
+func (s *sketch) reset() {
+	s.additions = 0
+	for i := range s.counters {
+		for j := range s.counters[i] {
+			s.counters[i][j] /= 2
+		}
+	}
+	for i := range s.doorkeeper {
+		s.doorkeeper[i] = 0
+	}
+}
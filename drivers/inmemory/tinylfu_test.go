@@ -0,0 +1,121 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package inmemory
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	traceKeys     = 10000
+	traceLength   = 200000
+	traceCapacity = 500
+)
+
+// zipfTrace returns accesses to keys with Zipf distribution
+func zipfTrace(seed int64) []string {
+	r := rand.New(rand.NewSource(seed))
+	z := rand.NewZipf(r, 1.1, 1, traceKeys-1)
+	trace := make([]string, traceLength)
+	for i := range trace {
+		trace[i] = strconv.FormatUint(z.Uint64(), 10)
+	}
+	return trace
+}
+
+// scanTrace returns Zipf trace interrupted by scans of keys which are accessed only once
+func scanTrace(seed int64) []string {
+	trace := zipfTrace(seed)
+	scan := 0
+	for i := 0; i < len(trace); i += 5000 {
+		for j := i; j < i+1000 && j < len(trace); j++ {
+			trace[j] = "scan" + strconv.Itoa(scan)
+			scan++
+		}
+	}
+	return trace
+}
+
+// hitRatio replays trace on the driver, missing keys are set after Get
+func hitRatio(d *Driver, trace []string, n int) float64 {
+	hits := 0
+	for i := 0; i < n; i++ {
+		key := trace[i%len(trace)]
+		if _, _, err := d.Get("CACHE", key); err == nil {
+			hits++
+		} else {
+			_ = d.Set("CACHE", key, nil, time.Hour)
+		}
+	}
+	return float64(hits) / float64(n)
+}
+
+func TestDriver_TinyLFU(t *testing.T) {
+	a := assert.New(t)
+	for name, trace := range map[string][]string{
+		"Zipf": zipfTrace(1),
+		"Scan": scanTrace(1),
+	} {
+		lru := hitRatio(NewWithOptions(Options{MaxEntries: traceCapacity}), trace, len(trace))
+		tinyLFU := hitRatio(NewWithOptions(Options{MaxEntries: traceCapacity, Policy: TinyLFU}), trace, len(trace))
+		t.Logf("%s: LRU %.3f, TinyLFU %.3f", name, lru, tinyLFU)
+		a.True(tinyLFU > lru, name)
+	}
+
+	d := NewWithOptions(Options{MaxEntries: 10, Policy: TinyLFU})
+	for i := 0; i < 100; i++ {
+		a.NoError(d.Set("CACHE", i%20, nil, time.Hour))
+	}
+	stats := d.Stats()
+	a.Equal(10, stats.Entries)
+	a.True(stats.Evictions >= 10)
+	d.InvalidateAll("CACHE")
+	a.Equal(0, d.Stats().Entries)
+	a.Equal(0, d.shards[0].policy("CACHE").len())
+}
+
+func BenchmarkDriver_HitRatio(b *testing.B) {
+	traces := map[string][]string{
+		"Zipf": zipfTrace(1),
+		"Scan": scanTrace(1),
+	}
+	policies := map[string]Policy{
+		"LRU":     LRU,
+		"TinyLFU": TinyLFU,
+	}
+	for traceName, trace := range traces {
+		for policyName, policy := range policies {
+			b.Run(traceName+"/"+policyName, func(b *testing.B) {
+				d := NewWithOptions(Options{MaxEntries: traceCapacity, Policy: policy})
+				b.ReportAllocs()
+				b.ResetTimer()
+				ratio := hitRatio(d, trace, b.N)
+				b.Logf("hit ratio %.3f on %d accesses", ratio, b.N)
+			})
+		}
+	}
+}
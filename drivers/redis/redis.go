@@ -22,17 +22,76 @@
 package redis
 
 import (
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/DLag/cachery"
 	"github.com/garyburd/redigo/redis"
 )
 
-// Driver type satisfies cachery.Driver interface
+// GenerationSuffix is appended to the name of the cache to get the key of generation counter
+const GenerationSuffix = ":generation"
+
+// Scripts of versioned driver, KEYS[1] is generation counter, ARGV[1] is name of the cache.
+// Keys of the cache are stored as "cacheName:generation:key" and listed in set "cacheName:generation".
+var (
+	versionedGet = redis.NewScript(1, `
+local g = redis.call('GET', KEYS[1]) or '0'
+local k = ARGV[1] .. ':' .. g .. ':' .. ARGV[2]
+local v = redis.call('GET', k)
+if not v then
+	return false
+end
+return {v, redis.call('PTTL', k)}
+`)
+	versionedSet = redis.NewScript(1, `
+local g = redis.call('GET', KEYS[1])
+if not g then
+	-- Counter marks the cache as versioned for tools which list caches
+	g = '0'
+	redis.call('SET', KEYS[1], g)
+end
+local s = ARGV[1] .. ':' .. g
+local k = s .. ':' .. ARGV[2]
+redis.call('SET', k, ARGV[3], 'PX', ARGV[4])
+redis.call('SADD', s, k)
+if redis.call('PTTL', s) < tonumber(ARGV[4]) then
+	redis.call('PEXPIRE', s, ARGV[4])
+end
+return g
+`)
+	versionedDel = redis.NewScript(1, `
+local g = redis.call('GET', KEYS[1]) or '0'
+local s = ARGV[1] .. ':' .. g
+local k = s .. ':' .. ARGV[2]
+redis.call('SREM', s, k)
+return redis.call('DEL', k)
+`)
+)
+
+// Driver type satisfies cachery.Driver, cachery.Scanner and cachery.Notifier interfaces
 type Driver struct {
-	client *redis.Pool
+	client       *redis.Pool
+	versioned    bool
+	handlers     map[int]func(e cachery.Event)
+	lastHandler  int
+	handlersLock sync.RWMutex
 }
 
+// keyEvents maps keyspace notifications to events, notifications of other commands are ignored
+var keyEvents = map[string]cachery.EventType{
+	"set":     cachery.EventSet,
+	"del":     cachery.EventInvalidated,
+	"incrby":  cachery.EventInvalidatedAll,
+	"expired": cachery.EventExpired,
+	"evicted": cachery.EventEvicted,
+}
+
+// ResubscribeDelay delay of reconnection to keyspace notifications after errors
+var ResubscribeDelay = time.Second
+
 // New creates redis driver instance
 func New(redis *redis.Pool) *Driver {
 	driver := new(Driver)
@@ -40,6 +99,16 @@ func New(redis *redis.Pool) *Driver {
 	return driver
 }
 
+// NewVersioned creates redis driver instance which keeps generation counter for every cache.
+// Keys embed current generation of the cache and InvalidateAll just increments the counter,
+// keys of previous generations are left to expire by their TTL.
+// Operations are done by Lua scripts which aren't compatible with Redis Cluster
+func NewVersioned(redis *redis.Pool) *Driver {
+	driver := New(redis)
+	driver.versioned = true
+	return driver
+}
+
 // DefaultPool creates redis pool with single host
 func DefaultPool(host string, maxIdle int, idleTimeout time.Duration) *redis.Pool {
 	return &redis.Pool{
@@ -49,6 +118,26 @@ func DefaultPool(host string, maxIdle int, idleTimeout time.Duration) *redis.Poo
 	}
 }
 
+// Namespace returns name of the Redis set which lists keys of the cache in current generation,
+// members of the set and keys of the cache are prefixed with namespace and colon
+func (c *Driver) Namespace(cacheName string) (ns string, err error) {
+	if !c.versioned {
+		return cacheName, nil
+	}
+	client := c.client.Get()
+	defer func() {
+		e := client.Close()
+		if err == nil {
+			err = e
+		}
+	}()
+	g, err := redis.Int64(client.Do("GET", cacheName+GenerationSuffix))
+	if err == redis.ErrNil {
+		g, err = 0, nil
+	}
+	return cacheName + ":" + strconv.FormatInt(g, 10), err
+}
+
 // Invalidate removes the key from the cache store
 func (c *Driver) Invalidate(cacheName string, key interface{}) error {
 	return c.del(cacheName, cachery.Key(key))
@@ -56,6 +145,10 @@ func (c *Driver) Invalidate(cacheName string, key interface{}) error {
 
 // InvalidateAll removes all keys from the cache store
 func (c *Driver) InvalidateAll(cacheName string) {
+	if c.versioned {
+		_ = c.incr(cacheName)
+		return
+	}
 	_ = c.delSet(cacheName)
 }
 
@@ -69,18 +162,23 @@ func (c *Driver) Set(cacheName string, key interface{}, val []byte, ttl time.Dur
 			err = e
 		}
 	}()
-	if err = client.Send("SADD", cacheName, cacheName+":"+skey); err != nil {
+	if c.versioned {
+		_, err = versionedSet.Do(client, cacheName+GenerationSuffix, cacheName, skey, val, milliseconds(ttl))
 		return
 	}
-	if err = client.Send("SET", cacheName+":"+skey, val); err != nil {
+	if err = client.Send("SADD", cacheName, cacheName+":"+skey); err != nil {
 		return
 	}
-	if err = client.Send("EXPIRE", cacheName+":"+skey, ttl.Seconds()); err != nil {
+	if err = client.Send("SET", cacheName+":"+skey, val, "PX", milliseconds(ttl)); err != nil {
 		return
 	}
 	if err = client.Flush(); err != nil {
 		return
 	}
+	// Replies are read to report errors of the commands
+	for n := 0; n < 2 && err == nil; n++ {
+		_, err = client.Receive()
+	}
 	return
 }
 
@@ -94,16 +192,171 @@ func (c *Driver) Get(cacheName string, key interface{}) (val []byte, ttl time.Du
 			err = e
 		}
 	}()
-	val, err = redis.Bytes(client.Do("GET", cacheName+":"+skey))
+	if c.versioned {
+		var reply []interface{}
+		reply, err = redis.Values(versionedGet.Do(client, cacheName+GenerationSuffix, cacheName, skey))
+		if err != nil {
+			return
+		}
+		var rawttl int64
+		_, err = redis.Scan(reply, &val, &rawttl)
+		ttl = time.Millisecond * time.Duration(rawttl)
+		return
+	}
+	// Value and TTL are read atomically in single round-trip
+	k := cacheName + ":" + skey
+	if err = client.Send("MULTI"); err != nil {
+		return
+	}
+	if err = client.Send("GET", k); err != nil {
+		return
+	}
+	if err = client.Send("PTTL", k); err != nil {
+		return
+	}
+	reply, err := redis.Values(client.Do("EXEC"))
 	if err != nil {
 		return
 	}
-	var rawttl int
-	rawttl, err = redis.Int(client.Do("TTL", cacheName+":"+skey))
-	ttl = time.Second * time.Duration(rawttl)
+	if val, err = redis.Bytes(reply[0], nil); err != nil {
+		return
+	}
+	var rawttl int64
+	rawttl, err = redis.Int64(reply[1], nil)
+	ttl = time.Millisecond * time.Duration(rawttl)
 	return
 }
 
+// Scan returns keys of the cache by SSCAN of its set, so keys could be repeated
+// and keys which are already expired could be returned
+func (c *Driver) Scan(cacheName string, cursor uint64, count int) (keys []string, next uint64, err error) {
+	ns, err := c.Namespace(cacheName)
+	if err != nil {
+		return nil, 0, err
+	}
+	client := c.client.Get()
+	defer func() {
+		e := client.Close()
+		if err == nil {
+			err = e
+		}
+	}()
+	args := []interface{}{ns, cursor}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	reply, err := redis.Values(client.Do("SSCAN", args...))
+	if err != nil {
+		return nil, 0, err
+	}
+	var members []string
+	if _, err = redis.Scan(reply, &next, &members); err != nil {
+		return nil, 0, err
+	}
+	keys = make([]string, 0, len(members))
+	for _, m := range members {
+		if strings.HasPrefix(m, ns+":") {
+			keys = append(keys, m[len(ns)+1:])
+		}
+	}
+	return keys, next, nil
+}
+
+// Subscribe registers function which receives keyspace notifications of the caches.
+// Notifications must be enabled on the server, e.g. "notify-keyspace-events Eg$xe".
+// Cache names containing colon aren't supported, generation increments of versioned caches
+// are reported as EventInvalidatedAll.
+// Connection to notifications is kept after the last subscription is removed.
+func (c *Driver) Subscribe(f func(e cachery.Event)) (unsubscribe func(), err error) {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	if c.handlers == nil {
+		psc, err := c.psubscribe()
+		if err != nil {
+			return nil, err
+		}
+		c.handlers = make(map[int]func(e cachery.Event))
+		go c.receive(psc)
+	}
+	c.lastHandler++
+	id := c.lastHandler
+	c.handlers[id] = f
+	return func() {
+		c.handlersLock.Lock()
+		delete(c.handlers, id)
+		c.handlersLock.Unlock()
+	}, nil
+}
+
+func (c *Driver) psubscribe() (redis.PubSubConn, error) {
+	psc := redis.PubSubConn{Conn: c.client.Get()}
+	channels := make([]interface{}, 0, len(keyEvents))
+	for name := range keyEvents {
+		channels = append(channels, "__keyevent@*__:"+name)
+	}
+	if err := psc.PSubscribe(channels...); err != nil {
+		_ = psc.Close()
+		return psc, err
+	}
+	return psc, nil
+}
+
+// receive dispatches notifications to subscribers, it reconnects after errors
+func (c *Driver) receive(psc redis.PubSubConn) {
+	for {
+		switch m := psc.Receive().(type) {
+		case redis.PMessage:
+			if e, ok := c.event(m.Channel, string(m.Data)); ok {
+				c.handlersLock.RLock()
+				for _, h := range c.handlers {
+					h(e)
+				}
+				c.handlersLock.RUnlock()
+			}
+		case error:
+			_ = psc.Close()
+			for {
+				time.Sleep(ResubscribeDelay)
+				var err error
+				if psc, err = c.psubscribe(); err == nil {
+					break
+				}
+			}
+		}
+	}
+}
+
+// event parses keyspace notification, channel is "__keyevent@<db>__:<command>" and data is name of the key
+func (c *Driver) event(channel, key string) (e cachery.Event, ok bool) {
+	if e.Type, ok = keyEvents[channel[strings.LastIndex(channel, ":")+1:]]; !ok {
+		return e, false
+	}
+	if e.Type == cachery.EventInvalidatedAll {
+		if !c.versioned || !strings.HasSuffix(key, GenerationSuffix) {
+			return e, false
+		}
+		e.CacheName = strings.TrimSuffix(key, GenerationSuffix)
+		return e, true
+	}
+	p := strings.Index(key, ":")
+	if p < 0 {
+		return e, false
+	}
+	e.CacheName, e.Key = key[:p], key[p+1:]
+	if c.versioned {
+		// Key of versioned cache is "cacheName:generation:key"
+		g := strings.Index(e.Key, ":")
+		if g < 0 {
+			return e, false
+		}
+		if _, err := strconv.ParseUint(e.Key[:g], 10, 64); err != nil {
+			return e, false
+		}
+		e.Key = e.Key[g+1:]
+	}
+	return e, true
+}
+
 func (c *Driver) delSet(cacheName string) (err error) {
 	client := c.client.Get()
 	defer func() {
@@ -124,6 +377,18 @@ func (c *Driver) delSet(cacheName string) (err error) {
 	return
 }
 
+func (c *Driver) incr(cacheName string) (err error) {
+	client := c.client.Get()
+	defer func() {
+		e := client.Close()
+		if err == nil {
+			err = e
+		}
+	}()
+	_, err = client.Do("INCR", cacheName+GenerationSuffix)
+	return
+}
+
 func (c *Driver) del(cacheName string, key string) (err error) {
 	client := c.client.Get()
 	defer func() {
@@ -132,8 +397,21 @@ func (c *Driver) del(cacheName string, key string) (err error) {
 			err = e
 		}
 	}()
+	if c.versioned {
+		_, err = versionedDel.Do(client, cacheName+GenerationSuffix, cacheName, key)
+		return
+	}
 	_ = client.Send("SREM", cacheName, cacheName+":"+key)
 	_ = client.Send("DEL", cacheName+":"+key)
 	err = client.Flush()
 	return
 }
+
+// milliseconds rounds ttl up to whole milliseconds
+func milliseconds(ttl time.Duration) int64 {
+	ms := int64((ttl + time.Millisecond - 1) / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	return ms
+}
@@ -25,7 +25,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/DLag/cachery"
 	"github.com/DLag/cachery/tests"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDriver_Cache1SetAndGet(t *testing.T) {
@@ -43,3 +45,133 @@ func TestDriver_Invalidate(t *testing.T) {
 	d2 := New(DefaultPool("127.0.0.1:6379", 3, time.Second*120))
 	tests.TestInvalidate(t, d1, d2)
 }
+
+func TestVersionedDriver_Cache1SetAndGet(t *testing.T) {
+	d := NewVersioned(DefaultPool("127.0.0.1:6379", 3, time.Second*120))
+	tests.TestCache1SetAndGet(t, d)
+}
+
+func TestVersionedDriver_Cache2SetAndGet(t *testing.T) {
+	d := NewVersioned(DefaultPool("127.0.0.1:6379", 3, time.Second*120))
+	tests.TestCache2SetAndGet(t, d)
+}
+
+func TestVersionedDriver_Invalidate(t *testing.T) {
+	d1 := NewVersioned(DefaultPool("127.0.0.1:6379", 3, time.Second*120))
+	d2 := NewVersioned(DefaultPool("127.0.0.1:6379", 3, time.Second*120))
+	tests.TestInvalidate(t, d1, d2)
+}
+
+func TestVersionedDriver_Namespace(t *testing.T) {
+	a := assert.New(t)
+	d := NewVersioned(DefaultPool("127.0.0.1:6379", 3, time.Second*120))
+	a.NoError(d.Set("VERSIONED", "a", []byte("1"), time.Second*10))
+	ns1, err := d.Namespace("VERSIONED")
+	a.NoError(err)
+	d.InvalidateAll("VERSIONED")
+	ns2, err := d.Namespace("VERSIONED")
+	a.NoError(err)
+	a.NotEqual(ns1, ns2)
+	_, _, err = d.Get("VERSIONED", "a")
+	a.Error(err)
+
+	ns, err := New(d.client).Namespace("VERSIONED")
+	a.NoError(err)
+	a.Equal("VERSIONED", ns)
+}
+
+func TestMilliseconds(t *testing.T) {
+	a := assert.New(t)
+	a.Equal(int64(1), milliseconds(0))
+	a.Equal(int64(1), milliseconds(time.Microsecond))
+	a.Equal(int64(2), milliseconds(time.Microsecond*1500))
+	a.Equal(int64(1500), milliseconds(time.Millisecond*1500))
+}
+
+func TestDriver_SubSecondTTL(t *testing.T) {
+	a := assert.New(t)
+	for _, d := range []*Driver{
+		New(DefaultPool("127.0.0.1:6379", 3, time.Second*120)),
+		NewVersioned(DefaultPool("127.0.0.1:6379", 3, time.Second*120)),
+	} {
+		a.NoError(d.Set("SUBSECOND", "key", []byte("value"), 1500*time.Millisecond))
+		val, ttl, err := d.Get("SUBSECOND", "key")
+		a.NoError(err)
+		a.Equal([]byte("value"), val)
+		a.True(ttl > time.Second && ttl <= 1500*time.Millisecond, ttl)
+
+		a.NoError(d.Set("SUBSECOND", "short", []byte("value"), 50*time.Millisecond))
+		time.Sleep(100 * time.Millisecond)
+		_, _, err = d.Get("SUBSECOND", "short")
+		a.Error(err)
+		d.InvalidateAll("SUBSECOND")
+	}
+}
+
+func TestDriver_Keys(t *testing.T) {
+	d := New(DefaultPool("127.0.0.1:6379", 3, time.Second*120))
+	tests.TestKeys(t, d)
+}
+
+func TestDriver_Scan(t *testing.T) {
+	tests.TestScan(t, New(DefaultPool("127.0.0.1:6379", 3, time.Second*120)))
+	tests.TestScan(t, NewVersioned(DefaultPool("127.0.0.1:6379", 3, time.Second*120)))
+}
+
+func TestDriver_Event(t *testing.T) {
+	a := assert.New(t)
+	d := New(nil)
+	e, ok := d.event("__keyevent@0__:set", "CACHE1:a:b")
+	a.True(ok)
+	a.Equal(cachery.Event{Type: cachery.EventSet, CacheName: "CACHE1", Key: "a:b"}, e)
+	e, ok = d.event("__keyevent@0__:expired", "CACHE1:1")
+	a.True(ok)
+	a.Equal(cachery.Event{Type: cachery.EventExpired, CacheName: "CACHE1", Key: "1"}, e)
+	_, ok = d.event("__keyevent@0__:del", "CACHE1")
+	a.False(ok)
+	_, ok = d.event("__keyevent@0__:sadd", "CACHE1:1")
+	a.False(ok)
+	_, ok = d.event("__keyevent@0__:incrby", "CACHE1"+GenerationSuffix)
+	a.False(ok)
+
+	d = NewVersioned(nil)
+	e, ok = d.event("__keyevent@0__:del", "CACHE1:3:a:b")
+	a.True(ok)
+	a.Equal(cachery.Event{Type: cachery.EventInvalidated, CacheName: "CACHE1", Key: "a:b"}, e)
+	e, ok = d.event("__keyevent@0__:incrby", "CACHE1"+GenerationSuffix)
+	a.True(ok)
+	a.Equal(cachery.Event{Type: cachery.EventInvalidatedAll, CacheName: "CACHE1"}, e)
+	_, ok = d.event("__keyevent@0__:expired", "CACHE1:3")
+	a.False(ok)
+	_, ok = d.event("__keyevent@0__:set", "CACHE1:x:a")
+	a.False(ok)
+}
+
+func TestDriver_Subscribe(t *testing.T) {
+	a := assert.New(t)
+	pool := DefaultPool("127.0.0.1:6379", 3, time.Second*120)
+	conn := pool.Get()
+	_, err := conn.Do("CONFIG", "SET", "notify-keyspace-events", "Eg$xe")
+	a.NoError(err)
+	a.NoError(conn.Close())
+
+	d := New(pool)
+	events := make(chan cachery.Event, 10)
+	unsubscribe, err := d.Subscribe(func(e cachery.Event) {
+		if e.CacheName == "EVENTS" {
+			events <- e
+		}
+	})
+	a.NoError(err)
+	defer unsubscribe()
+	a.NoError(d.Set("EVENTS", "key", []byte("value"), time.Second))
+	for _, expected := range []cachery.EventType{cachery.EventSet, cachery.EventExpired} {
+		select {
+		case e := <-events:
+			a.Equal(expected, e.Type)
+			a.Equal("key", e.Key)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event", expected)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cachery
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// encryptVersion version of EncryptSerializer envelope
+const encryptVersion byte = 1
+
+// encryptHeaderSize size of version byte and key ID
+const encryptHeaderSize = 5
+
+// ErrUnknownKey key ID isn't in the keyring
+var ErrUnknownKey = errors.New("cachery: unknown encryption key")
+
+// EncryptSerializer wraps Serializer and encrypts payloads with AES-GCM.
+// Envelope keeps ID of the key, so keys could be rotated:
+// new payloads are encrypted with the primary key and any key in the keyring decrypts them.
+// It satisfies ContextSerializer, DefaultCache authenticates cache name and key with the payload,
+// so entries moved under another key fail with ErrBadEntry. Binding works only if EncryptSerializer
+// is the outermost serializer of the cache, Serialize and Deserialize use empty context.
+type EncryptSerializer struct {
+	serializer Serializer
+	keys       map[uint32]cipher.AEAD
+	primary    uint32
+	lock       sync.RWMutex
+}
+
+// NewEncryptSerializer creates an instance of EncryptSerializer with primary key,
+// key must be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256
+func NewEncryptSerializer(serializer Serializer, keyID uint32, key []byte) (*EncryptSerializer, error) {
+	s := &EncryptSerializer{
+		serializer: serializer,
+		keys:       make(map[uint32]cipher.AEAD),
+	}
+	if err := s.AddKey(keyID, key); err != nil {
+		return nil, err
+	}
+	s.primary = keyID
+	return s, nil
+}
+
+// AddKey adds key to the keyring, it's used for decryption only until SetPrimary is called
+func (s *EncryptSerializer) AddKey(keyID uint32, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return errors.Wrap(err, "cachery: cannot create cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return errors.Wrap(err, "cachery: cannot create cipher")
+	}
+	s.lock.Lock()
+	s.keys[keyID] = aead
+	s.lock.Unlock()
+	return nil
+}
+
+// SetPrimary makes key from the keyring primary, new payloads are encrypted with it
+func (s *EncryptSerializer) SetPrimary(keyID uint32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.keys[keyID]; !ok {
+		return errors.Wrapf(ErrUnknownKey, "key ID %d", keyID)
+	}
+	s.primary = keyID
+	return nil
+}
+
+// RemoveKey removes key from the keyring, primary key cannot be removed
+func (s *EncryptSerializer) RemoveKey(keyID uint32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if keyID == s.primary {
+		return errors.New("cachery: cannot remove primary key")
+	}
+	delete(s.keys, keyID)
+	return nil
+}
+
+// Serialize serializes object and encrypts it with the primary key
+func (s *EncryptSerializer) Serialize(obj interface{}) ([]byte, error) {
+	return s.SerializeContext(obj, nil)
+}
+
+// SerializeContext serializes object and encrypts it with the primary key, context is authenticated with it
+func (s *EncryptSerializer) SerializeContext(obj interface{}, context []byte) ([]byte, error) {
+	plain, err := s.serializer.Serialize(obj)
+	if err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	keyID, aead := s.primary, s.keys[s.primary]
+	s.lock.RUnlock()
+
+	buf := make([]byte, encryptHeaderSize+aead.NonceSize(), encryptHeaderSize+aead.NonceSize()+len(plain)+aead.Overhead())
+	buf[0] = encryptVersion
+	binary.BigEndian.PutUint32(buf[1:encryptHeaderSize], keyID)
+	nonce := buf[encryptHeaderSize:]
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "cachery: cannot generate nonce")
+	}
+	// Header and context are authenticated as additional data
+	return aead.Seal(buf, nonce, plain, additionalData(buf[:encryptHeaderSize], context)), nil
+}
+
+// Deserialize decrypts payload and deserializes it to object,
+// payloads which cannot be authenticated return ErrBadEntry
+func (s *EncryptSerializer) Deserialize(src []byte, obj interface{}) error {
+	return s.DeserializeContext(src, obj, nil)
+}
+
+// DeserializeContext decrypts payload bound to the context and deserializes it to object,
+// payloads which cannot be authenticated return ErrBadEntry
+func (s *EncryptSerializer) DeserializeContext(src []byte, obj interface{}, context []byte) error {
+	if len(src) < encryptHeaderSize || src[0] != encryptVersion {
+		return errors.Wrap(ErrBadEntry, "unknown envelope")
+	}
+	keyID := binary.BigEndian.Uint32(src[1:encryptHeaderSize])
+	s.lock.RLock()
+	aead, ok := s.keys[keyID]
+	s.lock.RUnlock()
+	if !ok {
+		return errors.Wrapf(ErrBadEntry, "unknown key ID %d", keyID)
+	}
+	if len(src) < encryptHeaderSize+aead.NonceSize() {
+		return errors.Wrap(ErrBadEntry, "envelope is too short")
+	}
+	nonce := src[encryptHeaderSize : encryptHeaderSize+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, src[encryptHeaderSize+aead.NonceSize():], additionalData(src[:encryptHeaderSize], context))
+	if err != nil {
+		return errors.Wrap(ErrBadEntry, err.Error())
+	}
+	return s.serializer.Deserialize(plain, obj)
+}
+
+func additionalData(header, context []byte) []byte {
+	ad := make([]byte, 0, len(header)+len(context))
+	return append(append(ad, header...), context...)
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cachery
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type secret struct {
+	Name  string
+	Phone string
+}
+
+func TestEncryptSerializer(t *testing.T) {
+	a := assert.New(t)
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 16)
+
+	_, err := NewEncryptSerializer(new(GobSerializer), 1, []byte("short"))
+	a.Error(err)
+	s, err := NewEncryptSerializer(new(GobSerializer), 1, key1)
+	a.NoError(err)
+
+	orig := secret{Name: "secret", Phone: "+100500"}
+	buf, err := s.Serialize(orig)
+	a.NoError(err)
+	a.False(bytes.Contains(buf, []byte("secret")))
+	var dst secret
+	a.NoError(s.Deserialize(buf, &dst))
+	a.Equal(orig, dst)
+
+	t.Run("Rotation", func(t *testing.T) {
+		a.Error(s.SetPrimary(2))
+		a.NoError(s.AddKey(2, key2))
+		a.NoError(s.SetPrimary(2))
+		a.Error(s.RemoveKey(2))
+		buf2, err := s.Serialize(orig)
+		a.NoError(err)
+		var dst secret
+		a.NoError(s.Deserialize(buf2, &dst))
+		a.Equal(orig, dst)
+		a.NoError(s.Deserialize(buf, &dst))
+
+		a.NoError(s.RemoveKey(1))
+		a.Equal(ErrBadEntry, errors.Cause(s.Deserialize(buf, &dst)))
+	})
+	t.Run("Tampered", func(t *testing.T) {
+		buf, err := s.Serialize(orig)
+		a.NoError(err)
+		for _, i := range []int{0, 1, 10, len(buf) - 1} {
+			tampered := append([]byte{}, buf...)
+			tampered[i] ^= 1
+			a.Equal(ErrBadEntry, errors.Cause(s.Deserialize(tampered, &dst)), i)
+		}
+		a.Equal(ErrBadEntry, errors.Cause(s.Deserialize(buf[:8], &dst)))
+		a.Equal(ErrBadEntry, errors.Cause(s.Deserialize(nil, &dst)))
+	})
+	t.Run("Context", func(t *testing.T) {
+		buf, err := s.SerializeContext(orig, EntryContext("CACHE", "a"))
+		a.NoError(err)
+		var dst secret
+		a.NoError(s.DeserializeContext(buf, &dst, EntryContext("CACHE", "a")))
+		a.Equal(orig, dst)
+		// Entry moved under another key or cache isn't authenticated
+		a.Equal(ErrBadEntry, errors.Cause(s.DeserializeContext(buf, &dst, EntryContext("CACHE", "b"))))
+		a.Equal(ErrBadEntry, errors.Cause(s.DeserializeContext(buf, &dst, EntryContext("CACHEa", ""))))
+		a.Equal(ErrBadEntry, errors.Cause(s.Deserialize(buf, &dst)))
+	})
+}
+
+func TestDefaultCache_BadEntry(t *testing.T) {
+	a := assert.New(t)
+	s, err := NewEncryptSerializer(new(JSONSerializer), 1, bytes.Repeat([]byte{1}, 16))
+	a.NoError(err)
+	storage := mapDriver{"CACHE:a": []byte("garbage")}
+	c := NewDefault("CACHE", Config{
+		Expire:     time.Minute,
+		Lifetime:   time.Minute,
+		Serializer: s,
+		Driver:     storage,
+	})
+	var val string
+	a.NoError(c.Get("a", &val, func(key interface{}) (interface{}, error) {
+		return "fetched", nil
+	}))
+	a.Equal("fetched", val)
+	a.Equal(int64(1), c.Stats()["decode_refetches"])
+	a.Equal(int64(1), c.Stats()["fetches"])
+	a.NotEqual([]byte("garbage"), storage["CACHE:a"])
+}
+
+func TestDefaultCache_MovedEntry(t *testing.T) {
+	a := assert.New(t)
+	s, err := NewEncryptSerializer(new(JSONSerializer), 1, bytes.Repeat([]byte{1}, 16))
+	a.NoError(err)
+	storage := mapDriver{}
+	c := NewDefault("CACHE", Config{
+		Expire:     time.Minute,
+		Lifetime:   time.Minute,
+		Serializer: s,
+		Driver:     storage,
+	})
+	fetcher := func(key interface{}) (interface{}, error) {
+		return "value of " + key.(string), nil
+	}
+	var val string
+	a.NoError(c.Get("a", &val, fetcher))
+	a.Equal("value of a", val)
+	// Valid entry of key "a" copied under key "b" isn't accepted
+	storage["CACHE:b"] = storage["CACHE:a"]
+	a.NoError(c.Get("b", &val, fetcher))
+	a.Equal("value of b", val)
+	a.Equal(int64(1), c.Stats()["decode_refetches"])
+}
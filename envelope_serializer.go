@@ -0,0 +1,205 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cachery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Serializer IDs which are written to envelope by EnvelopeSerializer, custom serializers should use IDs above 127
+const (
+	SerializerIDGob  byte = 1
+	SerializerIDJSON byte = 2
+)
+
+// EnvelopeVersion version of EnvelopeSerializer format
+const EnvelopeVersion byte = 1
+
+// envelopeMagic marks payloads of EnvelopeSerializer
+var envelopeMagic = [2]byte{0xCA, 0xCE}
+
+const (
+	// envelopeHeaderSize magic, version, serializer ID, fingerprint and written-at time
+	envelopeHeaderSize = 2 + 1 + 1 + 8 + 8
+	// envelopeChecksumSize CRC32 at the end of envelope
+	envelopeChecksumSize = 4
+)
+
+// Envelope metadata of payload written by EnvelopeSerializer
+type Envelope struct {
+	// Version of envelope format
+	Version byte
+	// SerializerID of wrapped serializer
+	SerializerID byte
+	// Fingerprint of the type of serialized object
+	Fingerprint uint64
+	// WrittenAt time of serialization
+	WrittenAt time.Time
+}
+
+// EnvelopeSerializer wraps Serializer and adds envelope with metadata and checksum to payloads.
+// Payloads with another version, serializer ID or type fingerprint and corrupted ones
+// return ErrBadEntry, so DefaultCache refetches them instead of failing requests during rolling deploys.
+type EnvelopeSerializer struct {
+	serializer   Serializer
+	serializerID byte
+	fingerprints map[reflect.Type]uint64
+	lock         sync.RWMutex
+}
+
+// NewEnvelopeSerializer creates an instance of EnvelopeSerializer, id identifies wrapped serializer in envelopes
+func NewEnvelopeSerializer(serializer Serializer, id byte) *EnvelopeSerializer {
+	return &EnvelopeSerializer{
+		serializer:   serializer,
+		serializerID: id,
+		fingerprints: make(map[reflect.Type]uint64),
+	}
+}
+
+// Serialize serializes object and wraps it in envelope
+func (s *EnvelopeSerializer) Serialize(obj interface{}) ([]byte, error) {
+	payload, err := s.serializer.Serialize(obj)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, envelopeHeaderSize, envelopeHeaderSize+len(payload)+envelopeChecksumSize)
+	copy(buf, envelopeMagic[:])
+	buf[2] = EnvelopeVersion
+	buf[3] = s.serializerID
+	binary.BigEndian.PutUint64(buf[4:12], s.fingerprint(reflect.TypeOf(obj)))
+	binary.BigEndian.PutUint64(buf[12:20], uint64(time.Now().UnixNano()))
+	buf = append(buf, payload...)
+	var sum [envelopeChecksumSize]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(buf))
+	return append(buf, sum[:]...), nil
+}
+
+// Deserialize checks envelope and deserializes its payload to object,
+// fingerprint isn't checked if object is pointer to interface
+func (s *EnvelopeSerializer) Deserialize(src []byte, obj interface{}) error {
+	env, payload, err := s.Open(src)
+	if err != nil {
+		return err
+	}
+	if env.SerializerID != s.serializerID {
+		return errors.Wrapf(ErrBadEntry, "serializer ID %d instead of %d", env.SerializerID, s.serializerID)
+	}
+	if t := reflect.TypeOf(obj); t != nil && t.Kind() == reflect.Ptr && t.Elem().Kind() != reflect.Interface {
+		if fp := s.fingerprint(t.Elem()); env.Fingerprint != fp {
+			return errors.Wrapf(ErrBadEntry, "type fingerprint %x instead of %x", env.Fingerprint, fp)
+		}
+	}
+	return s.serializer.Deserialize(payload, obj)
+}
+
+// Open checks envelope and returns its metadata and payload
+func (s *EnvelopeSerializer) Open(src []byte) (Envelope, []byte, error) {
+	var env Envelope
+	if len(src) < envelopeHeaderSize+envelopeChecksumSize || !bytes.Equal(src[:2], envelopeMagic[:]) {
+		return env, nil, errors.Wrap(ErrBadEntry, "not an envelope")
+	}
+	if env.Version = src[2]; env.Version != EnvelopeVersion {
+		return env, nil, errors.Wrapf(ErrBadEntry, "envelope version %d", env.Version)
+	}
+	body := src[:len(src)-envelopeChecksumSize]
+	if binary.BigEndian.Uint32(src[len(body):]) != crc32.ChecksumIEEE(body) {
+		return env, nil, errors.Wrap(ErrBadEntry, "checksum mismatch")
+	}
+	env.SerializerID = src[3]
+	env.Fingerprint = binary.BigEndian.Uint64(src[4:12])
+	env.WrittenAt = time.Unix(0, int64(binary.BigEndian.Uint64(src[12:20])))
+	return env, body[envelopeHeaderSize:], nil
+}
+
+// fingerprint returns cached fingerprint of the type, pointers are dereferenced
+func (s *EnvelopeSerializer) fingerprint(t reflect.Type) uint64 {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return 0
+	}
+	s.lock.RLock()
+	fp, ok := s.fingerprints[t]
+	s.lock.RUnlock()
+	if ok {
+		return fp
+	}
+	h := fnv.New64a()
+	writeTypeSchema(h, t, make(map[reflect.Type]bool))
+	fp = h.Sum64()
+	s.lock.Lock()
+	s.fingerprints[t] = fp
+	s.lock.Unlock()
+	return fp
+}
+
+// writeTypeSchema writes description of the type which changes when its structure changes,
+// recursive types are described by their names
+func writeTypeSchema(w io.Writer, t reflect.Type, seen map[reflect.Type]bool) {
+	if seen[t] {
+		fmt.Fprintf(w, "%s.%s", t.PkgPath(), t.Name())
+		return
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		fmt.Fprint(w, "*")
+		writeTypeSchema(w, t.Elem(), seen)
+	case reflect.Slice:
+		fmt.Fprint(w, "[]")
+		writeTypeSchema(w, t.Elem(), seen)
+	case reflect.Array:
+		fmt.Fprintf(w, "[%d]", t.Len())
+		writeTypeSchema(w, t.Elem(), seen)
+	case reflect.Map:
+		fmt.Fprint(w, "map[")
+		writeTypeSchema(w, t.Key(), seen)
+		fmt.Fprint(w, "]")
+		writeTypeSchema(w, t.Elem(), seen)
+	case reflect.Struct:
+		seen[t] = true
+		fmt.Fprint(w, "struct{")
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			fmt.Fprintf(w, "%s %q ", f.Name, f.Tag)
+			writeTypeSchema(w, f.Type, seen)
+			fmt.Fprint(w, ";")
+		}
+		fmt.Fprint(w, "}")
+		delete(seen, t)
+	default:
+		fmt.Fprint(w, t.Kind().String())
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cachery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type envelopeV1 struct {
+	Name  string
+	Phone string
+	Next  *envelopeV1
+}
+
+type envelopeV2 struct {
+	Name   string
+	Phones []string
+	Next   *envelopeV2
+}
+
+func TestEnvelopeSerializer(t *testing.T) {
+	a := assert.New(t)
+	s := NewEnvelopeSerializer(new(GobSerializer), SerializerIDGob)
+	orig := envelopeV1{Name: "a", Phone: "1", Next: &envelopeV1{Name: "b"}}
+	before := time.Now()
+	buf, err := s.Serialize(&orig)
+	a.NoError(err)
+
+	var dst envelopeV1
+	a.NoError(s.Deserialize(buf, &dst))
+	a.Equal(orig, dst)
+	env, payload, err := s.Open(buf)
+	a.NoError(err)
+	a.Equal(EnvelopeVersion, env.Version)
+	a.Equal(SerializerIDGob, env.SerializerID)
+	a.False(env.WrittenAt.Before(before.Round(0)))
+	a.NotEmpty(payload)
+
+	var generic interface{}
+	json := NewEnvelopeSerializer(new(JSONSerializer), SerializerIDJSON)
+	jbuf, err := json.Serialize(orig)
+	a.NoError(err)
+	a.NoError(json.Deserialize(jbuf, &generic))
+	a.Equal("a", generic.(map[string]interface{})["Name"])
+
+	t.Run("Mismatch", func(t *testing.T) {
+		var v2 envelopeV2
+		a.Equal(ErrBadEntry, errors.Cause(s.Deserialize(buf, &v2)))
+		a.Equal(ErrBadEntry, errors.Cause(s.Deserialize(jbuf, &dst)))
+		raw, err := new(GobSerializer).Serialize(orig)
+		a.NoError(err)
+		a.Equal(ErrBadEntry, errors.Cause(s.Deserialize(raw, &dst)))
+	})
+	t.Run("Corrupted", func(t *testing.T) {
+		for _, i := range []int{2, 5, 15, len(buf) - 6, len(buf) - 1} {
+			corrupted := append([]byte{}, buf...)
+			corrupted[i] ^= 1
+			a.Equal(ErrBadEntry, errors.Cause(s.Deserialize(corrupted, &dst)), i)
+		}
+		a.Equal(ErrBadEntry, errors.Cause(s.Deserialize(buf[:10], &dst)))
+	})
+}
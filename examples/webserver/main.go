@@ -31,6 +31,7 @@ import (
 	"fmt"
 
 	"github.com/DLag/cachery"
+	"github.com/DLag/cachery/admin"
 	"github.com/gorilla/mux"
 )
 
@@ -43,6 +44,8 @@ func router() *mux.Router {
 	r.HandleFunc("/goods/{driver}", handlerGoods)
 	r.HandleFunc("/invalidate/goods/{driver}", handlerInvalidateGoods)
 	r.HandleFunc("/invalidate/tag/{tag}", handlerInvalidateByTag)
+	// Admin API for inspection and invalidation of all caches
+	r.PathPrefix("/admin/").Handler(http.StripPrefix("/admin", admin.New(cachery.DefaultManager())))
 	return r
 }
 
@@ -0,0 +1,185 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cachery
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrKeyCollision value in the cache store belongs to another key which has the same transformed key
+var ErrKeyCollision = errors.New("cachery: key collision")
+
+// KeyTransformer converts key to the form accepted by the cache store (e.g. memcached limits keys to 250 bytes without spaces)
+type KeyTransformer interface {
+	TransformKey(key string) string
+}
+
+// KeyTransformerFunc is a function which satisfies KeyTransformer interface
+type KeyTransformerFunc func(key string) string
+
+// TransformKey calls f(key)
+func (f KeyTransformerFunc) TransformKey(key string) string {
+	return f(key)
+}
+
+// HashTransformer replaces keys longer than MaxLength with hex encoded hash,
+// the hash is prefixed with PrefixLength first bytes of the key to keep keys readable.
+// Hashed keys are cut to MaxLength, the prefix is shortened first, then the hash,
+// so short MaxLength makes collisions likely and CheckKeyCollisions should be used
+type HashTransformer struct {
+	// MaxLength keys which aren't longer are kept as is, all keys are hashed and not cut if it's zero
+	MaxLength int
+	// PrefixLength number of first bytes of the key which are kept before the hash
+	PrefixLength int
+	// Hash constructor of hash function, SHA-256 if nil,
+	// non-cryptographic hashes like xxhash are faster but their collisions should be checked
+	Hash func() hash.Hash
+}
+
+// TransformKey returns hashed key if it's longer than MaxLength
+func (t HashTransformer) TransformKey(key string) string {
+	if len(key) <= t.MaxLength && t.MaxLength > 0 {
+		return key
+	}
+	newHash := t.Hash
+	if newHash == nil {
+		newHash = sha256.New
+	}
+	h := newHash()
+	h.Write([]byte(key))
+	digest := hex.EncodeToString(h.Sum(nil))
+	prefixLength := t.PrefixLength
+	if t.MaxLength > 0 {
+		if len(digest) > t.MaxLength {
+			digest = digest[:t.MaxLength]
+		}
+		if prefixLength > t.MaxLength-len(digest) {
+			prefixLength = t.MaxLength - len(digest)
+		}
+	}
+	prefix := key
+	if len(prefix) > prefixLength {
+		prefix = prefix[:prefixLength]
+	}
+	return prefix + digest
+}
+
+// EscapeTransformer escapes '%', spaces, control and non-ASCII characters with %XX sequences
+var EscapeTransformer KeyTransformer = KeyTransformerFunc(escapeKey)
+
+func escapeKey(key string) string {
+	const hexDigits = "0123456789ABCDEF"
+	n := 0
+	for i := 0; i < len(key); i++ {
+		if shouldEscape(key[i]) {
+			n++
+		}
+	}
+	if n == 0 {
+		return key
+	}
+	b := make([]byte, 0, len(key)+2*n)
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; shouldEscape(c) {
+			b = append(b, '%', hexDigits[c>>4], hexDigits[c&15])
+		} else {
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+func shouldEscape(c byte) bool {
+	return c <= ' ' || c >= 0x7f || c == '%'
+}
+
+// ChainTransformers applies transformers one by one, e.g. escaping before hashing of long keys
+func ChainTransformers(transformers ...KeyTransformer) KeyTransformer {
+	return KeyTransformerFunc(func(key string) string {
+		for _, t := range transformers {
+			key = t.TransformKey(key)
+		}
+		return key
+	})
+}
+
+// KeyDriver wraps driver and transforms keys before they reach it
+type KeyDriver struct {
+	Driver
+	transformer     KeyTransformer
+	checkCollisions bool
+}
+
+// NewKeyDriver creates an instance of KeyDriver,
+// if checkCollisions is set the original key is stored alongside the value
+// and Get returns ErrKeyCollision if it belongs to another key
+func NewKeyDriver(driver Driver, transformer KeyTransformer, checkCollisions bool) *KeyDriver {
+	return &KeyDriver{
+		Driver:          driver,
+		transformer:     transformer,
+		checkCollisions: checkCollisions,
+	}
+}
+
+// Get loads key from the cache store if it is not outdated
+func (d *KeyDriver) Get(cacheName string, key interface{}) (val []byte, ttl time.Duration, err error) {
+	skey := Key(key)
+	val, ttl, err = d.Driver.Get(cacheName, d.transformer.TransformKey(skey))
+	if err != nil || !d.checkCollisions {
+		return
+	}
+	l, n := binary.Uvarint(val)
+	if n <= 0 || uint64(len(val)-n) < l {
+		return nil, 0, errors.Wrap(ErrKeyCollision, "cannot read original key")
+	}
+	if string(val[n:n+int(l)]) != skey {
+		return nil, 0, ErrKeyCollision
+	}
+	return val[n+int(l):], ttl, nil
+}
+
+// Set saves key to the cache store
+func (d *KeyDriver) Set(cacheName string, key interface{}, val []byte, ttl time.Duration) error {
+	skey := Key(key)
+	if d.checkCollisions {
+		var b bytes.Buffer
+		var l [binary.MaxVarintLen64]byte
+		b.Grow(len(l) + len(skey) + len(val))
+		b.Write(l[:binary.PutUvarint(l[:], uint64(len(skey)))])
+		b.WriteString(skey)
+		b.Write(val)
+		val = b.Bytes()
+	}
+	return d.Driver.Set(cacheName, d.transformer.TransformKey(skey), val, ttl)
+}
+
+// Invalidate removes the key from the cache store
+func (d *KeyDriver) Invalidate(cacheName string, key interface{}) error {
+	return d.Driver.Invalidate(cacheName, d.transformer.TransformKey(Key(key)))
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cachery
+
+import (
+	"crypto/md5"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mapDriver map[string][]byte
+
+func (d mapDriver) Get(cacheName string, key interface{}) ([]byte, time.Duration, error) {
+	val, ok := d[cacheName+":"+key.(string)]
+	if !ok {
+		return nil, 0, ErrTest
+	}
+	return val, time.Minute, nil
+}
+
+func (d mapDriver) Set(cacheName string, key interface{}, val []byte, ttl time.Duration) error {
+	d[cacheName+":"+key.(string)] = val
+	return nil
+}
+
+func (d mapDriver) Invalidate(cacheName string, key interface{}) error {
+	delete(d, cacheName+":"+key.(string))
+	return nil
+}
+
+func (d mapDriver) InvalidateAll(cacheName string) {}
+
+func TestKeyTransformers(t *testing.T) {
+	a := assert.New(t)
+	long := strings.Repeat("k", 300)
+
+	h := HashTransformer{MaxLength: 250, PrefixLength: 4}
+	a.Equal("short", h.TransformKey("short"))
+	a.Len(h.TransformKey(long), 4+64)
+	a.True(strings.HasPrefix(h.TransformKey(long), "kkkk"))
+	a.NotEqual(h.TransformKey(long), h.TransformKey(long+"1"))
+	a.Len(HashTransformer{Hash: md5.New}.TransformKey("short"), 32)
+
+	a.Equal("a%20b%25c%0A%C3%A9", EscapeTransformer.TransformKey("a b%c\né"))
+	a.Equal("abc", EscapeTransformer.TransformKey("abc"))
+
+	chain := ChainTransformers(EscapeTransformer, HashTransformer{MaxLength: 5})
+	a.Equal("a%20b", chain.TransformKey("a b"))
+	a.Len(chain.TransformKey("a  b"), 5)
+
+	// Hashed keys never exceed MaxLength, the prefix is cut first
+	h = HashTransformer{MaxLength: 70, PrefixLength: 16}
+	a.Len(h.TransformKey(long), 70)
+	a.True(strings.HasPrefix(h.TransformKey(long), "kkkkkk"))
+	a.Len(HashTransformer{MaxLength: 10, PrefixLength: 4}.TransformKey(long), 10)
+	a.NotEqual(h.TransformKey(long), h.TransformKey(long+"1"))
+}
+
+func TestKeyDriver(t *testing.T) {
+	a := assert.New(t)
+	storage := mapDriver{}
+	collide := KeyTransformerFunc(func(key string) string {
+		return key[:1]
+	})
+
+	d := NewKeyDriver(storage, collide, false)
+	a.NoError(d.Set("CACHE", "abc", []byte("value"), time.Minute))
+	a.Equal([]byte("value"), storage["CACHE:a"])
+	val, _, err := d.Get("CACHE", "axy")
+	a.NoError(err)
+	a.Equal([]byte("value"), val)
+
+	d = NewKeyDriver(storage, collide, true)
+	a.NoError(d.Set("CACHE", "abc", []byte("value"), time.Minute))
+	val, _, err = d.Get("CACHE", "abc")
+	a.NoError(err)
+	a.Equal([]byte("value"), val)
+	_, _, err = d.Get("CACHE", "axy")
+	a.Equal(ErrKeyCollision, err)
+	storage["CACHE:a"] = []byte{200}
+	_, _, err = d.Get("CACHE", "abc")
+	a.Error(err)
+	a.NoError(d.Invalidate("CACHE", "abc"))
+	a.Empty(storage)
+
+	c := NewDefault("CACHE", Config{
+		Expire:             time.Minute,
+		Lifetime:           time.Minute,
+		Serializer:         new(GobSerializer),
+		Driver:             storage,
+		KeyTransformer:     HashTransformer{MaxLength: 8},
+		CheckKeyCollisions: true,
+	})
+	var v string
+	a.NoError(c.Get(strings.Repeat("long key ", 10), &v, func(key interface{}) (interface{}, error) {
+		return "fetched", nil
+	}))
+	a.Equal("fetched", v)
+	a.Len(storage, 1)
+	for k := range storage {
+		a.Len(k, len("CACHE:")+8)
+	}
+	a.Equal(storage, c.Config().Driver)
+}
@@ -21,23 +21,242 @@
 
 package cachery
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+// KeyTag is the struct field tag which overrides name of the field in the key,
+// fields tagged with "-" are skipped
+const KeyTag = "cachery"
 
 // Keyer provides idempotent key
 type Keyer interface {
 	Key() string
 }
 
-// Key returns representation of value which satisfies consistent key requirements
+// Tuple is composite key of several values
+type Tuple []interface{}
+
+// Key returns canonical representation of the tuple, e.g. (1,"a")
+func (t Tuple) Key() string {
+	var e keyEncoder
+	e.b.WriteByte('(')
+	for i := range t {
+		if i > 0 {
+			e.b.WriteByte(',')
+		}
+		e.encode(reflect.ValueOf(t[i]))
+	}
+	e.b.WriteByte(')')
+	return e.b.String()
+}
+
+// Key returns representation of value which satisfies consistent key requirements.
+// Strings, numbers, Keyer and fmt.Stringer are represented as is,
+// composite values are encoded deterministically:
+// slices as [e1,e2], maps sorted by keys as {k1:v1,k2:v2},
+// structs as {Field1:v1,Field2:v2} including unexported fields and pointers by their values.
+// Strings inside of composite values are quoted, so encodings of different composite values don't collide,
+// but top-level strings aren't, so a string equal to encoding of another value is the same key,
+// e.g. "1" and 1, "null" and nil, `["a"]` and []string{"a"}.
+// Pointers and maps which refer to themselves are encoded as back-references ^N,
+// where N is the number of levels up to the referred value.
+// Key panics on functions, channels and unsafe pointers, fields of such types must be tagged with "-".
 func Key(key interface{}) string {
-	switch key.(type) {
+	switch k := key.(type) {
 	case Keyer:
-		return key.(Keyer).Key()
+		return k.Key()
 	case string:
-		return key.(string)
+		return k
+	case time.Time:
+		return k.UTC().Format(time.RFC3339Nano)
+	case *time.Time:
+		if k != nil {
+			return k.UTC().Format(time.RFC3339Nano)
+		}
 	case fmt.Stringer:
-		return key.(fmt.Stringer).String()
+		return k.String()
+	}
+	v := reflect.ValueOf(key)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.String {
+		return v.String()
+	}
+	var e keyEncoder
+	e.encode(v)
+	return e.b.String()
+}
+
+var (
+	keyerType    = reflect.TypeOf((*Keyer)(nil)).Elem()
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// keyEncoder writes canonical representation of composite keys
+type keyEncoder struct {
+	b bytes.Buffer
+	// path references which are being encoded, it's used to detect cycles
+	path []keyRef
+}
+
+type keyRef struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+func (e *keyEncoder) encode(v reflect.Value) {
+	if !v.IsValid() {
+		e.b.WriteString("null")
+		return
+	}
+	v = readable(v)
+	if v.Kind() == reflect.Ptr && v.Type().Elem() == timeType && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Type() == timeType {
+		e.b.WriteString(strconv.Quote(v.Interface().(time.Time).UTC().Format(time.RFC3339Nano)))
+		return
+	}
+	if v.Kind() != reflect.Ptr || !v.IsNil() {
+		if v.Type().Implements(keyerType) {
+			e.b.WriteString(strconv.Quote(v.Interface().(Keyer).Key()))
+			return
+		}
+		if v.Type().Implements(stringerType) {
+			e.b.WriteString(strconv.Quote(v.Interface().(fmt.Stringer).String()))
+			return
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			break
+		}
+		ref := keyRef{ptr: v.Pointer(), typ: v.Type()}
+		if v.Kind() == reflect.Slice {
+			ref.len = v.Len()
+		}
+		for i := range e.path {
+			if e.path[i] == ref {
+				e.b.WriteByte('^')
+				e.b.WriteString(strconv.Itoa(len(e.path) - i))
+				return
+			}
+		}
+		e.path = append(e.path, ref)
+		defer func() {
+			e.path = e.path[:len(e.path)-1]
+		}()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.b.WriteString("null")
+			return
+		}
+		e.encode(v.Elem())
+	case reflect.String:
+		e.b.WriteString(strconv.Quote(v.String()))
+	case reflect.Bool:
+		e.b.WriteString(strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.b.WriteString(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.b.WriteString(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32:
+		e.b.WriteString(strconv.FormatFloat(v.Float(), 'g', -1, 32))
+	case reflect.Float64:
+		e.b.WriteString(strconv.FormatFloat(v.Float(), 'g', -1, 64))
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		e.b.WriteByte('(')
+		e.b.WriteString(strconv.FormatFloat(real(c), 'g', -1, 64))
+		e.b.WriteByte(',')
+		e.b.WriteString(strconv.FormatFloat(imag(c), 'g', -1, 64))
+		e.b.WriteByte(')')
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			e.b.WriteString(strconv.Quote(string(v.Bytes())))
+			return
+		}
+		e.b.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				e.b.WriteByte(',')
+			}
+			e.encode(v.Index(i))
+		}
+		e.b.WriteByte(']')
+	case reflect.Map:
+		type pair struct{ k, v string }
+		pairs := make([]pair, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			ke := keyEncoder{path: e.path}
+			ke.encode(k)
+			ve := keyEncoder{path: e.path}
+			ve.encode(v.MapIndex(k))
+			pairs = append(pairs, pair{ke.b.String(), ve.b.String()})
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			return pairs[i].k < pairs[j].k
+		})
+		e.b.WriteByte('{')
+		for i := range pairs {
+			if i > 0 {
+				e.b.WriteByte(',')
+			}
+			e.b.WriteString(pairs[i].k)
+			e.b.WriteByte(':')
+			e.b.WriteString(pairs[i].v)
+		}
+		e.b.WriteByte('}')
+	case reflect.Struct:
+		t := v.Type()
+		e.b.WriteByte('{')
+		n := 0
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := f.Name
+			if tag := f.Tag.Get(KeyTag); tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+			if n > 0 {
+				e.b.WriteByte(',')
+			}
+			n++
+			e.b.WriteString(name)
+			e.b.WriteByte(':')
+			e.encode(v.Field(i))
+		}
+		e.b.WriteByte('}')
 	default:
-		return fmt.Sprint(key)
+		// Channels, functions and unsafe pointers have no representation which is the same in every process
+		panic(fmt.Sprintf("cachery: %s cannot be encoded in key", v.Type()))
+	}
+}
+
+// readable returns value whose methods could be called even if it's obtained from unexported field.
+// Structs and arrays are copied to be addressable, so their unexported fields are readable too
+func readable(v reflect.Value) reflect.Value {
+	if !v.CanInterface() && v.CanAddr() {
+		return reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem()
+	}
+	if v.CanInterface() && !v.CanAddr() && (v.Kind() == reflect.Struct || v.Kind() == reflect.Array) {
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		return c
 	}
+	return v
 }
@@ -23,6 +23,8 @@ package cachery
 
 import (
 	"testing"
+	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -46,3 +48,113 @@ func TestKey(t *testing.T) {
 	a.Equal("test", Key("test"))
 	a.Equal("123", Key(123))
 }
+
+type testComposite struct {
+	ID      int
+	Name    string `cachery:"name"`
+	Skipped string `cachery:"-"`
+	private string
+	Parent  *testComposite
+	Labels  map[string]interface{}
+}
+
+func TestKey_Composite(t *testing.T) {
+	a := assert.New(t)
+	one := 1
+	a.Equal("1", Key(&one))
+	a.Equal("1.5", Key(1.5))
+	a.Equal("true", Key(true))
+	a.Equal("null", Key(nil))
+	a.Equal(`["a","b,c"]`, Key([]string{"a", "b,c"}))
+	a.NotEqual(Key([]string{"a,b"}), Key([]string{"a", "b"}))
+	a.Equal(`"ab"`, Key([]byte("ab")))
+	a.Equal(`{"a":1,"b":2,"c":3}`, Key(map[string]int{"c": 3, "a": 1, "b": 2}))
+	a.Equal(`{1:"a",2:"b"}`, Key(map[int]string{2: "b", 1: "a"}))
+	a.Equal(`(1,"a",["testKeyStruct"])`, Key(Tuple{1, "a", []interface{}{test{}}}))
+	a.Equal(`["2018-01-02T03:04:05Z"]`, Key([]time.Time{time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)}))
+
+	c := testComposite{ID: 1, Name: "a", Skipped: "x", private: "y", Labels: map[string]interface{}{"b": 2, "a": []int{1}}}
+	a.Equal(`{ID:1,name:"a",private:"y",Parent:null,Labels:{"a":[1],"b":2}}`, Key(c))
+	a.Equal(Key(c), Key(&c))
+	c.Skipped = "z"
+	a.Equal(`{ID:1,name:"a",private:"y",Parent:null,Labels:{"a":[1],"b":2}}`, Key(c))
+	p := testComposite{ID: 2, Parent: &c}
+	a.Equal(`{ID:2,name:"",private:"",Parent:{ID:1,name:"a",private:"y",Parent:null,Labels:{"a":[1],"b":2}},Labels:{}}`, Key(p))
+	a.Equal(Key(p), Key(testComposite{ID: 2, Parent: &testComposite{ID: 1, Name: "a", private: "y", Labels: c.Labels}}))
+}
+
+type testPrivate struct {
+	id   int
+	at   time.Time
+	key  test
+	tags map[string]testPrivateValue
+	any  interface{}
+}
+
+type testPrivateValue struct {
+	n int
+}
+
+func TestKey_Unexported(t *testing.T) {
+	a := assert.New(t)
+	at := time.Date(2018, 1, 2, 3, 4, 5, 6, time.FixedZone("X", 3600))
+	a.NotEqual(Key(testPrivate{id: 1}), Key(testPrivate{id: 2}))
+	a.Equal(`{id:1,at:"2018-01-02T02:04:05.000000006Z",key:"testKeyStruct",tags:{"a":{n:2}},any:{n:3}}`,
+		Key(testPrivate{id: 1, at: at, tags: map[string]testPrivateValue{"a": {2}}, any: testPrivateValue{3}}))
+	a.Equal(Key(testPrivate{id: 1, at: at}), Key(&testPrivate{id: 1, at: at.UTC()}))
+}
+
+type testCycle struct {
+	ID   int
+	Next *testCycle
+	Map  map[string]interface{}
+}
+
+func TestKey_Cycles(t *testing.T) {
+	a := assert.New(t)
+	c := &testCycle{ID: 1}
+	c.Next = c
+	a.Equal(`{ID:1,Next:{ID:1,Next:^1,Map:{}},Map:{}}`, Key(c))
+
+	m := map[string]interface{}{}
+	m["self"] = m
+	a.Equal(`{"self":^1}`, Key(m))
+
+	s := []interface{}{1, nil}
+	s[1] = s
+	a.Equal(`[1,^1]`, Key(s))
+
+	// Shared but acyclic references aren't back-references
+	shared := &testCycle{ID: 2}
+	a.Equal(`[{ID:2,Next:null,Map:{}},{ID:2,Next:null,Map:{}}]`, Key([]*testCycle{shared, shared}))
+}
+
+func TestKey_Unsupported(t *testing.T) {
+	a := assert.New(t)
+	var nilFunc func()
+	x := 1
+	for _, k := range []interface{}{
+		func() {},
+		nilFunc,
+		make(chan int),
+		unsafe.Pointer(&x),
+		[]interface{}{1, func() {}},
+		struct{ F func() }{},
+	} {
+		a.Panics(func() { Key(k) }, "%T", k)
+	}
+	// Tagged fields are skipped
+	a.Equal("{N:1}", Key(struct {
+		N int
+		F func() `cachery:"-"`
+	}{N: 1}))
+}
+
+func TestKey_Time(t *testing.T) {
+	a := assert.New(t)
+	at := time.Date(2018, 1, 2, 3, 4, 5, 0, time.FixedZone("X", 3600))
+	a.Equal("2018-01-02T02:04:05Z", Key(at))
+	a.Equal("2018-01-02T02:04:05Z", Key(&at))
+	a.Equal(`["2018-01-02T02:04:05Z"]`, Key([]*time.Time{&at}))
+	a.Equal(`[null]`, Key([]*time.Time{nil}))
+}
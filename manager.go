@@ -22,64 +22,280 @@
 package cachery
 
 import (
+	"io"
+	"reflect"
+	"sort"
 	"sync"
+
+	"github.com/pkg/errors"
 )
 
 var (
 	caches Manager
 	// Add cache to the internal cache Manager
 	Add = caches.Add
+	// MustAdd adds caches to the internal Manager or returns error if some of them already exist
+	MustAdd = caches.MustAdd
 	// Get cache from the internal Manager by its name or returns nil if could not find it
 	Get = caches.Get
+	// Remove cache from the internal Manager
+	Remove = caches.Remove
+	// Replace cache in the internal Manager
+	Replace = caches.Replace
+	// Names returns sorted names of caches in the internal Manager
+	Names = caches.Names
+	// Range calls function for every cache in the internal Manager
+	Range = caches.Range
+	// CachesByTag returns caches of the internal Manager which have specific tag
+	CachesByTag = caches.CachesByTag
+	// SetBroadcaster sets transport for tags invalidation of the internal Manager
+	SetBroadcaster = caches.SetBroadcaster
 	// InvalidateTags invalidates caches of internal Manager which have specific tags
 	InvalidateTags = caches.InvalidateTags
 	// InvalidateAll invalidates all caches of internal Manager
 	InvalidateAll = caches.InvalidateAll
 )
 
+// DefaultManager returns the internal Manager which is used by package functions
+func DefaultManager() *Manager {
+	return &caches
+}
+
+// ErrCacheExists cache with the same name is already in Manager
+var ErrCacheExists = errors.New("cachery: cache already exists")
+
+// ErrBroadcasterSet broadcaster of Manager is already set, TagBroadcaster cannot unsubscribe it
+var ErrBroadcasterSet = errors.New("cachery: broadcaster already set")
+
 // Manager consolidates caches and allows manipulations on them
 type Manager struct {
 	caches map[string]Cache
-	sync.Mutex
+	// tags index of cache names by tag, caches which don't implement Tagger are kept under untagged
+	tags        map[string]map[string]struct{}
+	untagged    map[string]struct{}
+	broadcaster TagBroadcaster
+	sync.RWMutex
 }
 
-// Add cache to Manager
+// Add cache to Manager, cache with the same name is overwritten
 func (m *Manager) Add(cache ...Cache) *Manager {
 	m.Lock()
-	if m.caches == nil {
-		m.caches = make(map[string]Cache)
-	}
 	for i := range cache {
-		m.caches[cache[i].Name()] = cache[i]
+		m.put(cache[i])
 	}
 	m.Unlock()
 	return m
 }
 
-// Get cache from Manager by its name or returns nil if could not find it
-func (m *Manager) Get(name string) Cache {
+// MustAdd adds caches to Manager or returns ErrCacheExists
+// without adding any of them if some name is already used
+func (m *Manager) MustAdd(cache ...Cache) error {
 	m.Lock()
 	defer m.Unlock()
+	names := make(map[string]struct{}, len(cache))
+	for i := range cache {
+		name := cache[i].Name()
+		if _, ok := m.caches[name]; ok {
+			return errors.Wrap(ErrCacheExists, name)
+		}
+		if _, ok := names[name]; ok {
+			return errors.Wrap(ErrCacheExists, name)
+		}
+		names[name] = struct{}{}
+	}
+	for i := range cache {
+		m.put(cache[i])
+	}
+	return nil
+}
+
+// Get cache from Manager by its name or returns nil if could not find it
+func (m *Manager) Get(name string) Cache {
+	m.RLock()
+	defer m.RUnlock()
 	if c, ok := m.caches[name]; ok {
 		return c
 	}
 	return nil
 }
 
-// InvalidateTags invalidates caches which have specific tags
-func (m *Manager) InvalidateTags(tags ...string) {
+// Remove cache from Manager by its name, returns removed cache or nil if could not find it
+func (m *Manager) Remove(name string) Cache {
 	m.Lock()
 	defer m.Unlock()
-	for i := range m.caches {
-		m.caches[i].InvalidateTags(tags...)
+	c, ok := m.caches[name]
+	if !ok {
+		return nil
+	}
+	m.unindex(c)
+	delete(m.caches, name)
+	return c
+}
+
+// Replace puts cache to Manager instead of the cache with the same name,
+// the old instance is closed if it implements io.Closer (e.g. DefaultCache).
+// Returns error of closing the old instance
+func (m *Manager) Replace(cache Cache) error {
+	m.Lock()
+	old := m.put(cache)
+	m.Unlock()
+	if closer, ok := old.(io.Closer); ok && !sameCache(old, cache) {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Names returns sorted names of caches in Manager
+func (m *Manager) Names() []string {
+	m.RLock()
+	names := make([]string, 0, len(m.caches))
+	for name := range m.caches {
+		names = append(names, name)
+	}
+	m.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// Range calls f for every cache in Manager sorted by name until f returns false.
+// Manager isn't locked during the calls, so f could modify it
+func (m *Manager) Range(f func(Cache) bool) {
+	for _, c := range m.list(nil) {
+		if !f(c) {
+			return
+		}
+	}
+}
+
+// CachesByTag returns caches sorted by name which have specific tag,
+// caches which don't implement Tagger are not returned
+func (m *Manager) CachesByTag(tag string) []Cache {
+	return m.list(func() map[string]struct{} {
+		return m.tags[tag]
+	})
+}
+
+// SetBroadcaster sets transport which delivers tags invalidation to Managers in peer processes
+// and subscribes Manager to invalidations from the peers, it returns ErrBroadcasterSet if it's called again
+func (m *Manager) SetBroadcaster(b TagBroadcaster) error {
+	m.Lock()
+	if m.broadcaster != nil {
+		m.Unlock()
+		return ErrBroadcasterSet
+	}
+	m.broadcaster = b
+	m.Unlock()
+	// Subscription is done without lock, broadcaster may deliver invalidations right away
+	if err := b.SubscribeTags(m.invalidateTags); err != nil {
+		m.Lock()
+		m.broadcaster = nil
+		m.Unlock()
+		return err
+	}
+	return nil
+}
+
+// InvalidateTags invalidates caches which have specific tags and sends invalidation to peers if broadcaster is set
+func (m *Manager) InvalidateTags(tags ...string) {
+	m.invalidateTags(tags...)
+	m.RLock()
+	b := m.broadcaster
+	m.RUnlock()
+	if b != nil {
+		_ = b.BroadcastTags(tags...)
 	}
 }
 
 // InvalidateAll invalidates all caches in Manager
 func (m *Manager) InvalidateAll() {
-	m.Lock()
-	defer m.Unlock()
-	for i := range m.caches {
-		m.caches[i].InvalidateAll()
+	for _, c := range m.list(nil) {
+		c.InvalidateAll()
 	}
 }
+
+func (m *Manager) invalidateTags(tags ...string) {
+	matched := m.list(func() map[string]struct{} {
+		names := make(map[string]struct{}, len(m.untagged))
+		for name := range m.untagged {
+			names[name] = struct{}{}
+		}
+		for _, t := range tags {
+			for name := range m.tags[t] {
+				names[name] = struct{}{}
+			}
+		}
+		return names
+	})
+	for _, c := range matched {
+		c.InvalidateTags(tags...)
+	}
+}
+
+// put adds cache to the storage and index, returns replaced cache, must be called under lock
+func (m *Manager) put(cache Cache) Cache {
+	if m.caches == nil {
+		m.caches = make(map[string]Cache)
+		m.tags = make(map[string]map[string]struct{})
+		m.untagged = make(map[string]struct{})
+	}
+	name := cache.Name()
+	old, ok := m.caches[name]
+	if ok {
+		m.unindex(old)
+	}
+	m.caches[name] = cache
+	tagger, ok := cache.(Tagger)
+	if !ok {
+		m.untagged[name] = struct{}{}
+		return old
+	}
+	for _, t := range tagger.Tags() {
+		if m.tags[t] == nil {
+			m.tags[t] = make(map[string]struct{})
+		}
+		m.tags[t][name] = struct{}{}
+	}
+	return old
+}
+
+// unindex removes cache from the index, must be called under lock
+func (m *Manager) unindex(cache Cache) {
+	name := cache.Name()
+	delete(m.untagged, name)
+	tagger, ok := cache.(Tagger)
+	if !ok {
+		return
+	}
+	for _, t := range tagger.Tags() {
+		delete(m.tags[t], name)
+		if len(m.tags[t]) == 0 {
+			delete(m.tags, t)
+		}
+	}
+}
+
+// list returns caches sorted by name, if filter is set only caches with names returned by filter are listed
+func (m *Manager) list(filter func() map[string]struct{}) []Cache {
+	m.RLock()
+	list := make([]Cache, 0, len(m.caches))
+	if filter == nil {
+		for _, c := range m.caches {
+			list = append(list, c)
+		}
+	} else {
+		for name := range filter() {
+			if c, ok := m.caches[name]; ok {
+				list = append(list, c)
+			}
+		}
+	}
+	m.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	return list
+}
+
+func sameCache(a, b Cache) bool {
+	return reflect.TypeOf(a) == reflect.TypeOf(b) && reflect.TypeOf(a).Comparable() && a == b
+}
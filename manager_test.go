@@ -0,0 +1,211 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cachery
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/DLag/cachery/drivers/mock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type managerTestCache struct {
+	name   string
+	closed int
+	sync.Mutex
+}
+
+func (c *managerTestCache) Get(key interface{}, dst interface{}, fetcher Fetcher) error {
+	return nil
+}
+
+func (c *managerTestCache) Name() string {
+	return c.name
+}
+
+func (c *managerTestCache) Invalidate(key interface{}) error {
+	return nil
+}
+
+func (c *managerTestCache) InvalidateTags(tags ...string) {}
+
+func (c *managerTestCache) InvalidateAll() {}
+
+func (c *managerTestCache) Close() error {
+	c.Lock()
+	c.closed++
+	c.Unlock()
+	return nil
+}
+
+func (c *managerTestCache) Closed() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.closed
+}
+
+func TestManager(t *testing.T) {
+	a := assert.New(t)
+	m := new(Manager)
+	c1 := &managerTestCache{name: "CACHE1"}
+	c2 := &managerTestCache{name: "CACHE2"}
+	c3 := &managerTestCache{name: "CACHE3"}
+
+	a.Empty(m.Names())
+	a.Nil(m.Remove("CACHE1"))
+	m.Add(c2, c1)
+	a.Equal([]string{"CACHE1", "CACHE2"}, m.Names())
+
+	t.Run("MustAdd", func(t *testing.T) {
+		err := m.MustAdd(c3, &managerTestCache{name: "CACHE1"})
+		a.Equal(ErrCacheExists, errors.Cause(err))
+		a.Nil(m.Get("CACHE3"))
+		err = m.MustAdd(c3, &managerTestCache{name: "CACHE3"})
+		a.Equal(ErrCacheExists, errors.Cause(err))
+		a.Nil(m.Get("CACHE3"))
+		a.NoError(m.MustAdd(c3))
+		a.Equal(c3, m.Get("CACHE3"))
+	})
+	t.Run("Range", func(t *testing.T) {
+		var names []string
+		m.Range(func(c Cache) bool {
+			names = append(names, c.Name())
+			return true
+		})
+		a.Equal([]string{"CACHE1", "CACHE2", "CACHE3"}, names)
+		names = nil
+		m.Range(func(c Cache) bool {
+			names = append(names, c.Name())
+			m.Remove(c.Name())
+			return len(names) < 2
+		})
+		a.Equal([]string{"CACHE1", "CACHE2"}, names)
+		a.Equal([]string{"CACHE3"}, m.Names())
+	})
+	t.Run("Remove", func(t *testing.T) {
+		a.Equal(c3, m.Remove("CACHE3"))
+		a.Nil(m.Get("CACHE3"))
+		a.Empty(m.Names())
+		a.Equal(0, c3.Closed())
+	})
+	t.Run("Replace", func(t *testing.T) {
+		a.NoError(m.Replace(c1))
+		a.Equal(c1, m.Get("CACHE1"))
+		a.NoError(m.Replace(c1))
+		a.Equal(0, c1.Closed())
+		c1new := &managerTestCache{name: "CACHE1"}
+		a.NoError(m.Replace(c1new))
+		a.Equal(c1new, m.Get("CACHE1"))
+		a.Equal(1, c1.Closed())
+	})
+	t.Run("Concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_ = m.Replace(&managerTestCache{name: "CACHE2"})
+			}()
+			go func() {
+				defer wg.Done()
+				a.NotNil(m.Get("CACHE1"))
+				m.InvalidateAll()
+			}()
+		}
+		wg.Wait()
+		a.Equal([]string{"CACHE1", "CACHE2"}, m.Names())
+	})
+}
+
+type testBroadcaster struct {
+	peers    []*testBroadcaster
+	handlers []func(tags ...string)
+	sent     [][]string
+}
+
+func (b *testBroadcaster) BroadcastTags(tags ...string) error {
+	b.sent = append(b.sent, tags)
+	for _, p := range b.peers {
+		for _, f := range p.handlers {
+			f(tags...)
+		}
+	}
+	return nil
+}
+
+func (b *testBroadcaster) SubscribeTags(f func(tags ...string)) error {
+	b.handlers = append(b.handlers, f)
+	return nil
+}
+
+func TestManager_Tags(t *testing.T) {
+	a := assert.New(t)
+	d := new(mock.Driver)
+	untagged := &managerTestCache{name: "CACHE0"}
+	c1 := NewDefault("CACHE1", Config{Driver: d, Tags: []string{"tag12", "tag1"}})
+	c2 := NewDefault("CACHE2", Config{Driver: d, Tags: []string{"tag12", "tag2"}})
+	m := new(Manager).Add(untagged, c1, c2)
+
+	a.Equal([]Cache{c1, c2}, m.CachesByTag("tag12"))
+	a.Equal([]Cache{c1}, m.CachesByTag("tag1"))
+	a.Empty(m.CachesByTag("tag3"))
+
+	t.Run("Invalidate", func(t *testing.T) {
+		d.On("InvalidateAll", "CACHE2").Once()
+		m.InvalidateTags("tag2", "tag3")
+		d.AssertExpectations(t)
+	})
+	t.Run("Reindex", func(t *testing.T) {
+		c2new := NewDefault("CACHE2", Config{Driver: d, Tags: []string{"tag3"}})
+		a.NoError(m.Replace(c2new))
+		a.Equal([]Cache{c1}, m.CachesByTag("tag12"))
+		a.Empty(m.CachesByTag("tag2"))
+		a.Equal([]Cache{c2new}, m.CachesByTag("tag3"))
+		m.Remove("CACHE1")
+		a.Empty(m.CachesByTag("tag12"))
+		a.Empty(m.CachesByTag("tag1"))
+		m.Add(c1)
+		a.Equal([]Cache{c1}, m.CachesByTag("tag1"))
+	})
+	t.Run("Broadcast", func(t *testing.T) {
+		d2 := new(mock.Driver)
+		peer := new(Manager).Add(NewDefault("CACHE1", Config{Driver: d2, Tags: []string{"tag1"}}))
+		b1, b2 := new(testBroadcaster), new(testBroadcaster)
+		b1.peers = []*testBroadcaster{b2}
+		b2.peers = []*testBroadcaster{b1}
+		a.NoError(m.SetBroadcaster(b1))
+		a.NoError(peer.SetBroadcaster(b2))
+		// Second subscription would invalidate caches twice
+		a.Equal(ErrBroadcasterSet, peer.SetBroadcaster(b2))
+		a.Len(b2.handlers, 1)
+
+		d.On("InvalidateAll", "CACHE1").Once()
+		d2.On("InvalidateAll", "CACHE1").Once()
+		m.InvalidateTags("tag1")
+		d.AssertExpectations(t)
+		d2.AssertExpectations(t)
+		a.Equal([][]string{{"tag1"}}, b1.sent)
+		a.Empty(b2.sent)
+	})
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cachery
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrObjectType stored object cannot be assigned to the destination
+var ErrObjectType = errors.New("cachery: stored object type doesn't match destination")
+
+// ObjectDriver is implemented by in-process drivers which store Go values without serialization
+type ObjectDriver interface {
+	// GetObject loads object from the cache store if it is not outdated
+	GetObject(cacheName string, key interface{}) (obj interface{}, ttl time.Duration, err error)
+	// SetObject saves object to the cache store
+	SetObject(cacheName string, key interface{}, obj interface{}, ttl time.Duration) error
+}
+
+// ObjectMode describes how DefaultCache stores values in ObjectDriver
+type ObjectMode int
+
+const (
+	// ObjectModeOff values are serialized by Serializer
+	ObjectModeOff ObjectMode = iota
+	// ObjectModeShared values returned by fetcher are stored as is and hits get them without copying.
+	// If destination is a pointer to the stored type or interface{}, callers share the stored value,
+	// otherwise the pointed value is copied shallowly, so slices, maps and pointers inside are shared.
+	// Neither fetcher nor callers may modify values after they are cached.
+	ObjectModeShared
+	// ObjectModeCopy values are stored as is and every hit gets their deep copy, so callers may modify it.
+	// Only exported fields of structs are copied deeply, values must not contain cycles.
+	ObjectModeCopy
+)
+
+// assignObject sets dst, which must be a pointer, to the stored object
+func assignObject(obj interface{}, dst interface{}) error {
+	if p, ok := dst.(*interface{}); ok {
+		*p = obj
+		return nil
+	}
+	d := reflect.ValueOf(dst)
+	if d.Kind() != reflect.Ptr || d.IsNil() {
+		return errors.New("cachery: destination must be a non-nil pointer")
+	}
+	d = d.Elem()
+	v := reflect.ValueOf(obj)
+	if v.Type().AssignableTo(d.Type()) {
+		d.Set(v)
+		return nil
+	}
+	if v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Type().AssignableTo(d.Type()) {
+		d.Set(v.Elem())
+		return nil
+	}
+	return errors.Wrapf(ErrObjectType, "%s to %s", v.Type(), d.Type())
+}
+
+// deepCopy returns copy of the object which doesn't share memory with it
+func deepCopy(obj interface{}) interface{} {
+	v := reflect.ValueOf(obj)
+	if !v.IsValid() {
+		return nil
+	}
+	c := reflect.New(v.Type()).Elem()
+	copyValue(c, v)
+	return c.Interface()
+}
+
+func copyValue(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+		dst.Set(reflect.New(src.Type().Elem()))
+		copyValue(dst.Elem(), src.Elem())
+	case reflect.Interface:
+		if src.IsNil() {
+			return
+		}
+		e := reflect.New(src.Elem().Type()).Elem()
+		copyValue(e, src.Elem())
+		dst.Set(e)
+	case reflect.Struct:
+		// Unexported fields are copied shallowly
+		dst.Set(src)
+		for i := 0; i < src.NumField(); i++ {
+			if dst.Field(i).CanSet() {
+				copyValue(dst.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Slice:
+		if src.IsNil() {
+			return
+		}
+		dst.Set(reflect.MakeSlice(src.Type(), src.Len(), src.Len()))
+		for i := 0; i < src.Len(); i++ {
+			copyValue(dst.Index(i), src.Index(i))
+		}
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			copyValue(dst.Index(i), src.Index(i))
+		}
+	case reflect.Map:
+		if src.IsNil() {
+			return
+		}
+		dst.Set(reflect.MakeMap(src.Type()))
+		t := src.Type().Elem()
+		for _, k := range src.MapKeys() {
+			e := reflect.New(t).Elem()
+			copyValue(e, src.MapIndex(k))
+			dst.SetMapIndex(k, e)
+		}
+	default:
+		dst.Set(src)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cachery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type objectDriver struct {
+	mapDriver
+	objects map[string]interface{}
+}
+
+func (d objectDriver) GetObject(cacheName string, key interface{}) (interface{}, time.Duration, error) {
+	obj, ok := d.objects[cacheName+":"+key.(string)]
+	if !ok {
+		if _, ok = d.mapDriver[cacheName+":"+key.(string)]; ok {
+			return nil, 0, ErrBadEntry
+		}
+		return nil, 0, ErrTest
+	}
+	return obj, time.Minute, nil
+}
+
+func (d objectDriver) SetObject(cacheName string, key interface{}, obj interface{}, ttl time.Duration) error {
+	delete(d.mapDriver, cacheName+":"+key.(string))
+	d.objects[cacheName+":"+key.(string)] = obj
+	return nil
+}
+
+type objectTestStruct struct {
+	Name  string
+	Tags  []string
+	Attrs map[string]*int
+	Any   interface{}
+	Next  *objectTestStruct
+	hid   []int
+}
+
+func TestAssignObject(t *testing.T) {
+	a := assert.New(t)
+	obj := &objectTestStruct{Name: "name"}
+
+	var p *objectTestStruct
+	a.NoError(assignObject(obj, &p))
+	a.True(p == obj)
+
+	var v objectTestStruct
+	a.NoError(assignObject(obj, &v))
+	a.Equal("name", v.Name)
+
+	var i interface{}
+	a.NoError(assignObject(obj, &i))
+	a.True(i == obj)
+
+	var s string
+	a.Equal(ErrObjectType, errors.Cause(assignObject(obj, &s)))
+	a.Error(assignObject(obj, v))
+}
+
+func TestDeepCopy(t *testing.T) {
+	a := assert.New(t)
+	one := 1
+	obj := &objectTestStruct{
+		Name:  "name",
+		Tags:  []string{"a", "b"},
+		Attrs: map[string]*int{"one": &one},
+		Any:   []int{1},
+		Next:  &objectTestStruct{Name: "next"},
+		hid:   []int{1},
+	}
+	c := deepCopy(obj).(*objectTestStruct)
+	a.Equal(obj, c)
+	a.False(c == obj)
+	c.Tags[0] = "c"
+	*c.Attrs["one"] = 2
+	c.Any.([]int)[0] = 2
+	c.Next.Name = "changed"
+	a.Equal("a", obj.Tags[0])
+	a.Equal(1, one)
+	a.Equal([]int{1}, obj.Any)
+	a.Equal("next", obj.Next.Name)
+	// Unexported fields are shared
+	c.hid[0] = 2
+	a.Equal(2, obj.hid[0])
+	a.Nil(deepCopy(nil))
+	a.Equal(5, deepCopy(5))
+}
+
+func TestDefaultCache_ObjectMode(t *testing.T) {
+	a := assert.New(t)
+	fetched := &objectTestStruct{Name: "name", Tags: []string{"a"}}
+	fetcher := func(key interface{}) (interface{}, error) {
+		return fetched, nil
+	}
+	d := objectDriver{mapDriver{}, map[string]interface{}{}}
+
+	shared := NewDefault("SHARED", Config{Driver: d, ObjectMode: ObjectModeShared, Lifetime: time.Minute, Expire: time.Minute})
+	var p1, p2 *objectTestStruct
+	a.NoError(shared.Get("key", &p1, fetcher))
+	a.NoError(shared.Get("key", &p2, fetcher))
+	a.True(p1 == fetched)
+	a.True(p2 == fetched)
+	var v objectTestStruct
+	a.NoError(shared.Get("key", &v, fetcher))
+	a.Equal(*fetched, v)
+	a.Equal(int64(1), shared.Stats()["fetches"])
+
+	copied := NewDefault("COPY", Config{Driver: d, ObjectMode: ObjectModeCopy, Lifetime: time.Minute, Expire: time.Minute})
+	a.NoError(copied.Get("key", &p1, fetcher))
+	a.Equal(fetched, p1)
+	a.False(p1 == fetched)
+	p1.Tags[0] = "b"
+	a.Equal("a", fetched.Tags[0])
+
+	// Serialized entry is refetched as an object
+	d.mapDriver["SHARED:bytes"] = []byte("value")
+	a.NoError(shared.Get("bytes", &p1, fetcher))
+	a.True(p1 == fetched)
+	a.Equal(int64(1), shared.Stats()["decode_refetches"])
+
+	// Type mismatch is a decode error
+	var s string
+	a.Equal(ErrObjectType, errors.Cause(shared.Get("key", &s, fetcher)))
+
+	// Drivers without ObjectDriver use Serializer
+	serialized := NewDefault("SERIALIZED", Config{Driver: mapDriver{}, ObjectMode: ObjectModeShared, Serializer: new(GobSerializer), Lifetime: time.Minute, Expire: time.Minute})
+	a.NoError(serialized.Get("key", &p1, fetcher))
+	a.NoError(serialized.Get("key", &v, fetcher))
+	a.Equal(*fetched, v)
+}
+
+func BenchmarkDefaultCache_Get(b *testing.B) {
+	obj := &objectTestStruct{Name: "name", Tags: []string{"a", "b"}}
+	fetcher := func(key interface{}) (interface{}, error) {
+		return obj, nil
+	}
+	bb := map[string]Config{
+		"Gob":    {Driver: mapDriver{}, Serializer: new(GobSerializer)},
+		"Shared": {Driver: objectDriver{mapDriver{}, map[string]interface{}{}}, ObjectMode: ObjectModeShared},
+		"Copy":   {Driver: objectDriver{mapDriver{}, map[string]interface{}{}}, ObjectMode: ObjectModeCopy},
+	}
+	for name, config := range bb {
+		b.Run(name, func(b *testing.B) {
+			config.Lifetime, config.Expire = time.Hour, time.Hour
+			c := NewDefault("CACHE", config)
+			var dst *objectTestStruct
+			if err := c.Get("key", &dst, fetcher); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := c.Get("key", &dst, fetcher); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -25,8 +25,14 @@ import (
 	"bytes"
 	"encoding/gob"
 	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
 )
 
+// ErrBadEntry entry in the cache store is corrupted or cannot be authenticated, DefaultCache treats it as a miss
+var ErrBadEntry = errors.New("cachery: bad entry")
+
 // Serializer describes serializer interface
 type Serializer interface {
 	// Serialize serializes object to []byte
@@ -35,16 +41,60 @@ type Serializer interface {
 	Deserialize(src []byte, obj interface{}) error
 }
 
+// ContextSerializer is implemented by serializers which bind payloads to the entry,
+// DefaultCache passes EntryContext of the key to them, payloads of other entries fail with ErrBadEntry
+type ContextSerializer interface {
+	// SerializeContext serializes object bound to the context
+	SerializeContext(obj interface{}, context []byte) ([]byte, error)
+	// DeserializeContext deserializes []byte which is bound to the context to object
+	DeserializeContext(src []byte, obj interface{}, context []byte) error
+}
+
+// EntryContext returns context of the entry which DefaultCache passes to ContextSerializer
+func EntryContext(cacheName string, key interface{}) []byte {
+	k := Key(key)
+	context := make([]byte, 0, len(cacheName)+1+len(k))
+	context = append(context, cacheName...)
+	// Zero byte separates cache name and key
+	context = append(context, 0)
+	return append(context, k...)
+}
+
+// buffers pool of buffers for serialization, result is copied out of the buffer, so it doesn't grow
+// from scratch on every call (see GobUnpooled and GzipUnpooled in BenchmarkSerializer_Serialize)
+var buffers = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// maxPooledBuffer buffers which grew bigger aren't returned to the pool to not keep memory of rare huge objects
+const maxPooledBuffer = 1 << 20
+
+func getBuffer() *bytes.Buffer {
+	return buffers.Get().(*bytes.Buffer)
+}
+
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	buffers.Put(b)
+}
+
 // GobSerializer implements Serializer with Gob serialization
 type GobSerializer struct{}
 
 // Serialize serializes object to []byte
 func (GobSerializer) Serialize(obj interface{}) ([]byte, error) {
-	w := new(bytes.Buffer)
-	w.Reset()
-	e := gob.NewEncoder(w)
-	err := e.Encode(obj)
-	return w.Bytes(), err
+	w := getBuffer()
+	defer putBuffer(w)
+	// Encoder cannot be reused, it sends type definitions only once per stream
+	if err := gob.NewEncoder(w).Encode(obj); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), w.Bytes()...), nil
 }
 
 // Deserialize deserializes []byte to object
@@ -58,6 +108,7 @@ type JSONSerializer struct{}
 
 // Serialize serializes object to []byte
 func (JSONSerializer) Serialize(obj interface{}) ([]byte, error) {
+	// json.Marshal pools its buffers already
 	return json.Marshal(obj)
 }
 
@@ -19,61 +19,76 @@
 // OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
 // WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
 
-package cachery
+package cachery_test
 
 import (
-	"fmt"
-	"math/rand"
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
 	"testing"
-	"time"
 
-	"github.com/stretchr/testify/assert"
+	"github.com/DLag/cachery"
+	"github.com/DLag/cachery/tests"
 )
 
-type A struct {
-	Name     string
-	BirthDay time.Time
-	Phone    string
-	Siblings int
-	Spouse   bool
-	Money    float64
+func TestSerializer(t *testing.T) {
+	tt := map[string]cachery.Serializer{
+		"Gob":  new(cachery.GobSerializer),
+		"JSON": new(cachery.JSONSerializer),
+	}
+	for k := range tt {
+		t.Run(k, func(t *testing.T) {
+			tests.TestSerializer(t, tt[k])
+		})
+	}
+	t.Run("GobTime", func(t *testing.T) {
+		tests.TestSerializerTime(t, new(cachery.GobSerializer))
+	})
 }
 
-func randString(l int) string {
-	buf := make([]byte, l)
-	for i := 0; i < (l+1)/2; i++ {
-		buf[i] = byte(rand.Intn(256))
-	}
-	return fmt.Sprintf("%x", buf)[:l]
+// unpooledGobSerializer serializes without buffer pooling, it's a baseline for benchmarks
+type unpooledGobSerializer struct {
+	cachery.GobSerializer
 }
 
-func TestSerializer(t *testing.T) {
-	a := assert.New(t)
+func (unpooledGobSerializer) Serialize(obj interface{}) ([]byte, error) {
+	w := new(bytes.Buffer)
+	err := gob.NewEncoder(w).Encode(obj)
+	return w.Bytes(), err
+}
 
-	tt := map[string]Serializer{
-		"Gob":  new(GobSerializer),
-		"JSON": new(JSONSerializer),
+func BenchmarkSerializer_Serialize(b *testing.B) {
+	obj := tests.SerializerTestData(10)
+	bb := map[string]cachery.Serializer{
+		"Gob":          new(cachery.GobSerializer),
+		"GobUnpooled":  new(unpooledGobSerializer),
+		"JSON":         new(cachery.JSONSerializer),
+		"Gzip":         cachery.NewCompressSerializer(new(cachery.JSONSerializer), 0),
+		"GzipUnpooled": cachery.NewCompressSerializer(new(cachery.JSONSerializer), 0, unpooledGzipCodec{}),
 	}
-	orig := make([]A, 0, 1000)
-	for i := 0; i < 1000; i++ {
-		orig = append(orig, A{
-			Name:     randString(16),
-			BirthDay: time.Now().UTC().Round(time.Millisecond),
-			Phone:    randString(10),
-			Siblings: rand.Intn(5),
-			Spouse:   rand.Intn(2) == 1,
-			Money:    rand.Float64(),
+	for name, s := range bb {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.Serialize(obj); err != nil {
+					b.Fatal(err)
+				}
+			}
 		})
 	}
+}
 
-	for k := range tt {
-		t.Run(k, func(t *testing.T) {
-			buf, err := tt[k].Serialize(orig)
-			a.NoError(err)
-			var dst []A
-			err = tt[k].Deserialize(buf, &dst)
-			a.NoError(err)
-			a.EqualValues(orig, dst)
-		})
+// unpooledGzipCodec allocates compressor for every payload, it's a baseline for benchmarks
+type unpooledGzipCodec struct {
+	cachery.GzipCodec
+}
+
+func (unpooledGzipCodec) Compress(src []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
 	}
+	err := w.Close()
+	return b.Bytes(), err
 }
@@ -0,0 +1,46 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.12
+// +build go1.12
+
+package cbor
+
+import "github.com/fxamacker/cbor"
+
+// encOptions sorts map keys to make output deterministic and keeps nanoseconds of time.Time
+var encOptions = cbor.EncOptions{
+	Sort:        cbor.SortCanonical,
+	TimeRFC3339: true,
+}
+
+// Serializer implements cachery.Serializer with CBOR serialization
+type Serializer struct{}
+
+// Serialize serializes object to []byte
+func (Serializer) Serialize(obj interface{}) ([]byte, error) {
+	return cbor.Marshal(obj, encOptions)
+}
+
+// Deserialize deserializes []byte to object
+func (Serializer) Deserialize(src []byte, obj interface{}) error {
+	return cbor.Unmarshal(src, obj)
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.17
+// +build go1.17
+
+package protobuf
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+// ErrNotMessage object doesn't implement proto.Message
+var ErrNotMessage = errors.New("cachery/protobuf: object is not proto.Message")
+
+// Serializer implements cachery.Serializer with Protocol Buffers serialization,
+// objects must implement proto.Message, so fetcher should return pointers to generated messages
+type Serializer struct{}
+
+// Serialize serializes object to []byte
+func (Serializer) Serialize(obj interface{}) ([]byte, error) {
+	m, ok := obj.(proto.Message)
+	if !ok {
+		return nil, errors.Wrapf(ErrNotMessage, "%T", obj)
+	}
+	return proto.Marshal(m)
+}
+
+// Deserialize deserializes []byte to object
+func (Serializer) Deserialize(src []byte, obj interface{}) error {
+	m, ok := obj.(proto.Message)
+	if !ok {
+		return errors.Wrapf(ErrNotMessage, "%T", obj)
+	}
+	return proto.Unmarshal(src, m)
+}
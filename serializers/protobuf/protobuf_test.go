@@ -0,0 +1,59 @@
+// Copyright (c) 2018 Dmytro Lahoza <dmitry@lagoza.name>
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.17
+// +build go1.17
+
+package protobuf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSerializer(t *testing.T) {
+	a := assert.New(t)
+	s := new(Serializer)
+	now := time.Now()
+	orig := &timestamp.Timestamp{Seconds: now.Unix(), Nanos: int32(now.Nanosecond())}
+	buf, err := s.Serialize(orig)
+	a.NoError(err)
+	dst := new(timestamp.Timestamp)
+	a.NoError(s.Deserialize(buf, dst))
+	a.True(proto.Equal(orig, dst))
+
+	buf, err = s.Serialize(&wrappers.StringValue{Value: "string"})
+	a.NoError(err)
+	str := new(wrappers.StringValue)
+	a.NoError(s.Deserialize(buf, str))
+	a.Equal("string", str.Value)
+
+	_, err = s.Serialize("string")
+	a.Equal(ErrNotMessage, errors.Cause(err))
+	var s2 string
+	a.Equal(ErrNotMessage, errors.Cause(s.Deserialize(buf, &s2)))
+}
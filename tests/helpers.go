@@ -25,6 +25,8 @@ package tests
 
 import (
 	"errors"
+	"fmt"
+	"math/rand"
 	"sync"
 	"testing"
 	"time"
@@ -388,3 +390,148 @@ func TestInvalidate(t *testing.T, d1, d2 cachery.Driver) {
 		a.Equal(4, c2Fetcher.Calls())
 	})
 }
+
+func TestKeys(t *testing.T, d cachery.Driver) {
+	a := assert.New(t)
+	type composite struct {
+		ID   int
+		Tags map[string]bool `cachery:"tags"`
+	}
+	d.InvalidateAll("KEYS")
+
+	a.NoError(d.Set("KEYS", 1, []byte("int"), time.Second*3))
+	val, _, err := d.Get("KEYS", "1")
+	a.NoError(err)
+	a.Equal("int", string(val))
+
+	a.NoError(d.Set("KEYS", []string{"a", "b"}, []byte("slice"), time.Second*3))
+	val, _, err = d.Get("KEYS", []string{"a", "b"})
+	a.NoError(err)
+	a.Equal("slice", string(val))
+
+	key := composite{ID: 1, Tags: map[string]bool{"a": true, "b": false, "c": true}}
+	a.NoError(d.Set("KEYS", key, []byte("struct"), time.Second*3))
+	val, _, err = d.Get("KEYS", &composite{ID: 1, Tags: map[string]bool{"c": true, "b": false, "a": true}})
+	a.NoError(err)
+	a.Equal("struct", string(val))
+
+	a.NoError(d.Invalidate("KEYS", key))
+	_, _, err = d.Get("KEYS", key)
+	a.Error(err)
+	d.InvalidateAll("KEYS")
+}
+
+// TestScan checks that Scanner returns every key of the cache
+func TestScan(t *testing.T, d cachery.Driver) {
+	a := assert.New(t)
+	s, ok := d.(cachery.Scanner)
+	if !a.True(ok) {
+		return
+	}
+	d.InvalidateAll("SCAN")
+	d.InvalidateAll("SCAN2")
+	keys, next, err := s.Scan("SCAN", 0, 10)
+	a.NoError(err)
+	a.Empty(keys)
+	a.Equal(uint64(0), next)
+
+	expected := make(map[string]bool)
+	for i := 0; i < 55; i++ {
+		a.NoError(d.Set("SCAN", i, []byte("value"), time.Second*3))
+		expected[cachery.Key(i)] = true
+	}
+	a.NoError(d.Set("SCAN", []string{"a", "b"}, []byte("value"), time.Second*3))
+	expected[cachery.Key([]string{"a", "b"})] = true
+	a.NoError(d.Set("SCAN2", "other", []byte("value"), time.Second*3))
+
+	found := make(map[string]bool)
+	calls := 0
+	for cursor := uint64(0); ; {
+		keys, cursor, err = s.Scan("SCAN", cursor, 10)
+		a.NoError(err)
+		for _, k := range keys {
+			found[k] = true
+		}
+		if calls++; cursor == 0 || calls > 100 {
+			break
+		}
+	}
+	a.Equal(expected, found)
+	a.True(calls > 1)
+	d.InvalidateAll("SCAN")
+	d.InvalidateAll("SCAN2")
+}
+
+type SerializerTestStruct struct {
+	Name     string
+	BirthDay time.Time
+	Phone    string
+	Siblings int
+	Spouse   bool
+	Money    float64
+	Tags     map[string]string
+}
+
+func randString(l int) string {
+	buf := make([]byte, l)
+	for i := 0; i < (l+1)/2; i++ {
+		buf[i] = byte(rand.Intn(256))
+	}
+	return fmt.Sprintf("%x", buf)[:l]
+}
+
+// SerializerTestData returns n random values of SerializerTestStruct
+func SerializerTestData(n int) []SerializerTestStruct {
+	data := make([]SerializerTestStruct, 0, n)
+	for i := 0; i < n; i++ {
+		data = append(data, SerializerTestStruct{
+			Name: randString(16),
+			// Not every format keeps monotonic clock and nanoseconds, TestSerializerTime checks the latter
+			BirthDay: time.Now().UTC().Round(time.Millisecond),
+			Phone:    randString(10),
+			Siblings: rand.Intn(5),
+			Spouse:   rand.Intn(2) == 1,
+			Money:    rand.Float64(),
+			Tags:     map[string]string{randString(4): randString(8)},
+		})
+	}
+	return data
+}
+
+func TestSerializer(t *testing.T, s cachery.Serializer) {
+	a := assert.New(t)
+	orig := SerializerTestData(1000)
+	buf, err := s.Serialize(orig)
+	a.NoError(err)
+	var dst []SerializerTestStruct
+	a.NoError(s.Deserialize(buf, &dst))
+	// Some formats decode time in local time zone
+	for i := range dst {
+		dst[i].BirthDay = dst[i].BirthDay.UTC()
+	}
+	a.EqualValues(orig, dst)
+
+	var str string
+	buf, err = s.Serialize("string")
+	a.NoError(err)
+	a.NoError(s.Deserialize(buf, &str))
+	a.Equal("string", str)
+}
+
+// TestSerializerTime checks that s keeps nanoseconds of time.Time
+func TestSerializerTime(t *testing.T, s cachery.Serializer) {
+	a := assert.New(t)
+	orig := time.Date(2018, 3, 4, 5, 6, 7, 123456789, time.UTC)
+	buf, err := s.Serialize(orig)
+	a.NoError(err)
+	var dst time.Time
+	a.NoError(s.Deserialize(buf, &dst))
+	a.True(orig.Equal(dst), "%v != %v", orig, dst)
+
+	obj := SerializerTestStruct{Name: "a", BirthDay: orig}
+	buf, err = s.Serialize(obj)
+	a.NoError(err)
+	var dstObj SerializerTestStruct
+	a.NoError(s.Deserialize(buf, &dstObj))
+	a.True(orig.Equal(dstObj.BirthDay), "%v != %v", orig, dstObj.BirthDay)
+}
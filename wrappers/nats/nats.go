@@ -24,6 +24,7 @@
 package nats
 
 import (
+	"sync"
 	"time"
 
 	"github.com/DLag/cachery"
@@ -32,12 +33,14 @@ import (
 	"github.com/satori/go.uuid"
 )
 
-// Wrapper type satisfies cachery.Wrapper interface
+// Wrapper type satisfies cachery.Wrapper, cachery.TagBroadcaster, cachery.Scanner and cachery.Notifier interfaces
 type Wrapper struct {
 	cachery.Driver
-	nats    *nats.EncodedConn
-	subject string
-	id      string
+	nats        *nats.EncodedConn
+	subject     string
+	id          string
+	tagHandlers []func(tags ...string)
+	tagLock     sync.RWMutex
 }
 
 type message struct {
@@ -45,6 +48,7 @@ type message struct {
 	Command   string
 	CacheName string
 	Key       string
+	Tags      []string
 }
 
 // New creates an instance of Wrapper
@@ -110,6 +114,42 @@ func (c *Wrapper) Get(cacheName string, key interface{}) (val []byte, ttl time.D
 	return c.Driver.Get(cacheName, cachery.Key(key))
 }
 
+// Scan delegates to the wrapped driver, it returns ErrScanNotSupported if the driver isn't a Scanner
+func (c *Wrapper) Scan(cacheName string, cursor uint64, count int) (keys []string, next uint64, err error) {
+	s, ok := c.Driver.(cachery.Scanner)
+	if !ok {
+		return nil, 0, cachery.ErrScanNotSupported
+	}
+	return s.Scan(cacheName, cursor, count)
+}
+
+// Subscribe delegates to the wrapped driver, it returns ErrEventsNotSupported if the driver isn't a Notifier
+func (c *Wrapper) Subscribe(f func(e cachery.Event)) (unsubscribe func(), err error) {
+	n, ok := c.Driver.(cachery.Notifier)
+	if !ok {
+		return nil, cachery.ErrEventsNotSupported
+	}
+	return n.Subscribe(f)
+}
+
+// BroadcastTags sends tags invalidation to peers
+func (c *Wrapper) BroadcastTags(tags ...string) error {
+	msg := message{
+		Sender:  c.id,
+		Command: "InvalidateTags",
+		Tags:    tags,
+	}
+	return c.send(msg)
+}
+
+// SubscribeTags registers function which is called on tags invalidation received from peers
+func (c *Wrapper) SubscribeTags(f func(tags ...string)) error {
+	c.tagLock.Lock()
+	c.tagHandlers = append(c.tagHandlers, f)
+	c.tagLock.Unlock()
+	return nil
+}
+
 func (c *Wrapper) send(msg message) error {
 	err := c.nats.Publish(c.subject, msg)
 	if err != nil {
@@ -128,5 +168,12 @@ func (c *Wrapper) consumer(msg *message) {
 		_ = c.Driver.Invalidate(msg.CacheName, msg.Key)
 	case "InvalidateAll":
 		c.Driver.InvalidateAll(msg.CacheName)
+	case "InvalidateTags":
+		c.tagLock.RLock()
+		handlers := c.tagHandlers
+		c.tagLock.RUnlock()
+		for _, f := range handlers {
+			f(msg.Tags...)
+		}
 	}
 }
@@ -25,10 +25,12 @@ package nats
 
 import (
 	"testing"
+	"time"
 
 	"github.com/DLag/cachery/drivers/inmemory"
 	"github.com/DLag/cachery/tests"
 	"github.com/nats-io/go-nats"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDriver_Cache1SetAndGet(t *testing.T) {
@@ -46,3 +48,33 @@ func TestDriver_Invalidate(t *testing.T) {
 	d2 := Default(inmemory.Default(), nats.DefaultURL, "cachery-test")
 	tests.TestInvalidate(t, d1, d2)
 }
+
+func TestWrapper_BroadcastTags(t *testing.T) {
+	a := assert.New(t)
+	d1 := Default(inmemory.Default(), nats.DefaultURL, "cachery-test")
+	d2 := Default(inmemory.Default(), nats.DefaultURL, "cachery-test")
+	received := make(chan []string, 1)
+	a.NoError(d2.SubscribeTags(func(tags ...string) {
+		received <- tags
+	}))
+	a.NoError(d1.SubscribeTags(func(tags ...string) {
+		t.Error("sender should skip its own messages")
+	}))
+	a.NoError(d1.BroadcastTags("tag1", "tag2"))
+	select {
+	case tags := <-received:
+		a.Equal([]string{"tag1", "tag2"}, tags)
+	case <-time.After(time.Second):
+		t.Error("tags invalidation wasn't received")
+	}
+}
+
+func TestDriver_Keys(t *testing.T) {
+	d := Default(inmemory.Default(), nats.DefaultURL, "cachery-test")
+	tests.TestKeys(t, d)
+}
+
+func TestDriver_Scan(t *testing.T) {
+	d := Default(inmemory.Default(), nats.DefaultURL, "cachery-test")
+	tests.TestScan(t, d)
+}